@@ -7,6 +7,8 @@ import (
 	"os"
 	"slices"
 	"smad/models"
+
+	"github.com/google/uuid"
 )
 
 func fileExists(filename string) bool {
@@ -85,7 +87,25 @@ func processUsers(users *[]models.User, groups *[]models.Group) {
 	}
 }
 
-func readConfig() models.AppConfig {
+// Gives every object a GUID and initial update sequence number, like they had all been created
+// in the order they appear in the configuration
+func assignObjectIds(config *models.AppConfig) {
+	for idx := range config.Groups {
+		config.HighestUSN++
+		config.Groups[idx].ObjectGUID = uuid.NewString()
+		config.Groups[idx].USNCreated = config.HighestUSN
+		config.Groups[idx].USNChanged = config.HighestUSN
+	}
+
+	for idx := range config.Users {
+		config.HighestUSN++
+		config.Users[idx].ObjectGUID = uuid.NewString()
+		config.Users[idx].USNCreated = config.HighestUSN
+		config.Users[idx].USNChanged = config.HighestUSN
+	}
+}
+
+func readConfig() *models.AppConfig {
 	fs, err := os.Open("configs/config.json")
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -105,7 +125,31 @@ func readConfig() models.AppConfig {
 		config.Configuration.Port = 389
 	}
 
-	// If crtfile and keyfile are set, then they must also exist
+	// Maximum size of single LDAP message, default matches AD's MaxReceiveBuffer (10MB)
+	if config.Configuration.MaxReceiveBuffer <= 0 {
+		config.Configuration.MaxReceiveBuffer = 10485760
+	}
+
+	// Maximum number of values returned for multi-valued attribute, default matches AD's MaxValRange
+	if config.Configuration.MaxValRange <= 0 {
+		config.Configuration.MaxValRange = 1500
+	}
+
+	// Administrative limits, defaults match AD's lDAPAdminLimits. Searches return at most
+	// MaxPageSize entries (or page), paged searches of connection can keep MaxResultSetSize
+	// entries for the next pages and idle connections are closed after MaxConnIdleTime seconds.
+	if config.Configuration.MaxPageSize <= 0 {
+		config.Configuration.MaxPageSize = 1000
+	}
+	if config.Configuration.MaxResultSetSize <= 0 {
+		config.Configuration.MaxResultSetSize = 262144
+	}
+	if config.Configuration.MaxConnIdleTime <= 0 {
+		config.Configuration.MaxConnIdleTime = 900
+	}
+
+	// If crtfile and keyfile are set, then they must also exist. Certificate is either used for
+	// ldaps, or for upgrading plain connections with StartTLS.
 	config.Configuration.UseSSL = false
 	if config.Configuration.CrtFile != "" && config.Configuration.KeyFile != "" {
 		if !fileExists(config.Configuration.KeyFile) {
@@ -115,12 +159,15 @@ func readConfig() models.AppConfig {
 			log.Fatalln("'crtFile' set in config.json but file not found")
 		}
 
-		config.Configuration.UseSSL = true
+		config.Configuration.UseSSL = !config.Configuration.StartTLS
+	} else if config.Configuration.StartTLS {
+		log.Fatalln("'startTLS' set in config.json but 'crtFile' or 'keyFile' is missing")
 	}
 
 	// Finally read in users and groups
 	readUsersAndGroups(&config)
 	processUsers(&config.Users, &config.Groups)
+	assignObjectIds(&config)
 
-	return config
+	return &config
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -13,7 +14,7 @@ import (
 	"github.com/google/uuid"
 )
 
-func logEvent(connectId uuid.UUID, msgNum uint8, tag ber.Tag) {
+func logEvent(connectId uuid.UUID, msgNum int64, tag ber.Tag) {
 	prefix := fmt.Sprintf("CID: %s, message number %d, ", connectId, msgNum)
 
 	switch tag {
@@ -23,41 +24,98 @@ func logEvent(connectId uuid.UUID, msgNum uint8, tag ber.Tag) {
 		log.Printf("%s unbind request OP", prefix)
 	case 3:
 		log.Printf("%s search request OP", prefix)
+	case 6:
+		log.Printf("%s modify request OP", prefix)
+	case 8:
+		log.Printf("%s add request OP", prefix)
 	case 10:
 		log.Printf("%s delete request OP", prefix)
+	case 12:
+		log.Printf("%s modify DN request OP", prefix)
+	case 14:
+		log.Printf("%s compare request OP", prefix)
+	case 16:
+		log.Printf("%s abandon request OP", prefix)
+	case 23:
+		log.Printf("%s extended request OP", prefix)
 	default:
 		log.Printf("%s unsupported OP (tag id: %d)", prefix, tag)
 	}
 }
 
-func handlePacket(conn net.Conn, p *ber.Packet, connectId uuid.UUID, bindSuccessful *bool, appConfig models.AppConfig) bool {
-	// Packet should have 2 children (message number, and operation)
-	if len(p.Children) != 2 {
+func handlePacket(sess *ldap.Session, p *ber.Packet, connectId uuid.UUID, appConfig *models.AppConfig) bool {
+	// Packet should have 2 or 3 children (message number, operation and optional controls)
+	if len(p.Children) != 2 && len(p.Children) != 3 {
 		log.Println("Unknown packet")
 		return false
 	}
 
-	msgNum := uint8(p.Children[0].ByteValue[0])
+	msgNum, err := ldap.ParseMessageID(p.Children[0])
+	if err != nil {
+		log.Printf("CID: %s, invalid message id: %v\n", connectId, err)
+		ldap.SendNoticeOfDisconnection(sess, ldap.ResultProtocolError, ldap.DecodingErrorMessage)
+		sess.Close()
+		return true
+	}
+
 	logEvent(connectId, msgNum, p.Children[1].Tag)
 
 	isCommand := p.Children[1].ClassType == ber.ClassApplication
 
 	// Unbind request OP
 	if isCommand && p.Children[1].Tag == 2 {
-		conn.Close()
+		sess.Close()
 		return true
 	}
 
-	// Other commands
+	op := ldap.NewOperation(msgNum, p.Children[1].Tag)
+	if isCommand && !ldap.ProcessControls(sess, op, p) {
+		return false
+	}
+
+	// Other commands. Bind is processed only after all outstanding operations have completed
+	// (RFC 4511, section 4.2.1), except change notification searches which are abandoned.
+	// Everything else runs concurrently and answers when done.
 	if isCommand && p.Children[1].Tag == 0 {
 		// Bind request OP
-		*bindSuccessful = ldap.HandleBindRequest(conn, p.Children[1], msgNum, appConfig.Users)
+		sess.WaitForBind()
+		ldap.HandleBindRequest(sess, p.Children[1], msgNum, appConfig)
 	} else if isCommand && p.Children[1].Tag == 3 {
 		// Search request OP
-		ldap.HandleSearchRequest(conn, p.Children[1], msgNum, *bindSuccessful, appConfig)
+		sess.Run(op, func() {
+			ldap.HandleSearchRequest(sess, p.Children[1], op, appConfig)
+		})
+	} else if isCommand && p.Children[1].Tag == 6 {
+		// Modify request OP
+		sess.Run(op, func() {
+			ldap.HandleModifyRequest(sess, p.Children[1], op, appConfig)
+		})
+	} else if isCommand && p.Children[1].Tag == 8 {
+		// Add request OP
+		sess.Run(op, func() {
+			ldap.HandleAddRequest(sess, p.Children[1], op, appConfig)
+		})
 	} else if isCommand && p.Children[1].Tag == 10 {
 		// Delete request OP
-		ldap.HandleDeleteRequest(conn, p.Children[1], msgNum, *bindSuccessful, appConfig)
+		sess.Run(op, func() {
+			ldap.HandleDeleteRequest(sess, p.Children[1], op, appConfig)
+		})
+	} else if isCommand && p.Children[1].Tag == 12 {
+		// Modify DN request OP
+		sess.Run(op, func() {
+			ldap.HandleModifyDNRequest(sess, p.Children[1], op, appConfig)
+		})
+	} else if isCommand && p.Children[1].Tag == 14 {
+		// Compare request OP
+		sess.Run(op, func() {
+			ldap.HandleCompareRequest(sess, p.Children[1], op, appConfig)
+		})
+	} else if isCommand && p.Children[1].Tag == 16 {
+		// Abandon request OP
+		ldap.HandleAbandonRequest(sess, p.Children[1])
+	} else if isCommand && p.Children[1].Tag == 23 {
+		// Extended request OP
+		ldap.HandleExtendedRequest(sess, p.Children[1], msgNum, appConfig)
 	} else {
 		ber.PrintPacket(p.Children[1])
 	}
@@ -65,27 +123,36 @@ func handlePacket(conn net.Conn, p *ber.Packet, connectId uuid.UUID, bindSuccess
 	return false
 }
 
-func handleConnection(conn net.Conn, appConfig models.AppConfig) {
-	request := make([]byte, 4096)
-	bindSuccessful := false
+func handleConnection(conn net.Conn, appConfig *models.AppConfig) {
+	sess := ldap.NewSession(conn)
 	connectId, _ := uuid.NewRandom()
 
+	// Let outstanding operations finish (or fail on closed connection) before leaving
+	defer sess.Wait()
+
 	log.Printf("CID: %s, new connection, waiting for data.\n", connectId)
 	for {
-		// Wait 30s for data
-		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-		_, err := conn.Read(request)
+		// Idle connection is closed, if client doesn't send anything in time. Deadline
+		// stays armed while the message is read, so a partial message can't stall us.
+		err := sess.WaitForMessage(time.Duration(appConfig.Configuration.MaxConnIdleTime) * time.Second)
+		var p *ber.Packet
+		if err == nil {
+			p, err = sess.ReadMessage(appConfig.Configuration.MaxReceiveBuffer)
+		}
 
 		if err != nil {
-			if err != io.EOF {
+			if errors.Is(err, ldap.ErrMessageTooLarge) || errors.Is(err, ldap.ErrMalformedMessage) || errors.Is(err, io.ErrUnexpectedEOF) {
+				// Client sent something we can't frame, so tell it why before hanging up
+				log.Printf("CID: %s, invalid request: %v\n", connectId, err)
+				ldap.SendNoticeOfDisconnection(sess, ldap.ResultProtocolError, ldap.DecodingErrorMessage)
+			} else if err != io.EOF {
 				log.Println("Failed to read request", err)
 			}
-			conn.Close()
+			sess.Close()
 			return
 		}
 
-		p := ber.DecodePacket(request)
-		if handlePacket(conn, p, connectId, &bindSuccessful, appConfig) {
+		if handlePacket(sess, p, connectId, appConfig) {
 			break
 		}
 	}
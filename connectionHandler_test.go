@@ -5,6 +5,7 @@ import (
 	ber "github.com/go-asn1-ber/asn1-ber"
 	"github.com/google/uuid"
 	"smad/internal/mocks"
+	"smad/ldap"
 	"smad/models"
 	"testing"
 )
@@ -43,10 +44,11 @@ func TestHandlePacketBindRequest(t *testing.T) {
 	// Create mock connection
 	conn := mocks.NewMockConn()
 	connectId, _ := uuid.NewRandom()
-	bindSuccessful := false
+	sess := ldap.NewSession(conn)
+	sess.BindSuccessful = false
 
 	// Create test configuration
-	appConfig := models.AppConfig{
+	appConfig := &models.AppConfig{
 		Users: []models.User{
 			{
 				Upn:      "testuser",
@@ -60,7 +62,7 @@ func TestHandlePacketBindRequest(t *testing.T) {
 	packet := createMockPacket(1, 0, true) // Tag 0 = Bind Request
 
 	// Test that handlePacket doesn't close connection for bind request
-	closeConnection := handlePacket(conn, packet, connectId, &bindSuccessful, appConfig)
+	closeConnection := handlePacket(sess, packet, connectId, appConfig)
 
 	if closeConnection {
 		t.Error("handlePacket should not close connection for bind request")
@@ -74,16 +76,17 @@ func TestHandlePacketUnbindRequest(t *testing.T) {
 	// Create mock connection
 	conn := mocks.NewMockConn()
 	connectId, _ := uuid.NewRandom()
-	bindSuccessful := true
+	sess := ldap.NewSession(conn)
+	sess.BindSuccessful = true
 
 	// Create test configuration
-	appConfig := models.AppConfig{}
+	appConfig := &models.AppConfig{}
 
 	// Create a mock unbind request packet
 	packet := createMockPacket(2, 2, true) // Tag 2 = Unbind Request
 
 	// Test that handlePacket closes connection for unbind request
-	closeConnection := handlePacket(conn, packet, connectId, &bindSuccessful, appConfig)
+	closeConnection := handlePacket(sess, packet, connectId, appConfig)
 
 	if !closeConnection {
 		t.Error("handlePacket should close connection for unbind request")
@@ -98,10 +101,11 @@ func TestHandlePacketSearchRequest(t *testing.T) {
 	// Create mock connection
 	conn := mocks.NewMockConn()
 	connectId, _ := uuid.NewRandom()
-	bindSuccessful := true
+	sess := ldap.NewSession(conn)
+	sess.BindSuccessful = true
 
 	// Create test configuration
-	appConfig := models.AppConfig{
+	appConfig := &models.AppConfig{
 		Groups: []models.Group{
 			{
 				Cn: "testgroup",
@@ -113,7 +117,8 @@ func TestHandlePacketSearchRequest(t *testing.T) {
 	packet := createMockPacket(3, 3, true) // Tag 3 = Search Request
 
 	// Test that handlePacket doesn't close connection for search request
-	closeConnection := handlePacket(conn, packet, connectId, &bindSuccessful, appConfig)
+	closeConnection := handlePacket(sess, packet, connectId, appConfig)
+	sess.Wait()
 
 	if closeConnection {
 		t.Error("handlePacket should not close connection for search request")
@@ -127,10 +132,11 @@ func TestHandlePacketDeleteRequest(t *testing.T) {
 	// Create mock connection
 	conn := mocks.NewMockConn()
 	connectId, _ := uuid.NewRandom()
-	bindSuccessful := true
+	sess := ldap.NewSession(conn)
+	sess.BindSuccessful = true
 
 	// Create test configuration
-	appConfig := models.AppConfig{
+	appConfig := &models.AppConfig{
 		Groups: []models.Group{
 			{
 				Cn: "testgroup",
@@ -142,7 +148,8 @@ func TestHandlePacketDeleteRequest(t *testing.T) {
 	packet := createMockPacket(4, 10, true) // Tag 10 = Delete Request
 
 	// Test that handlePacket doesn't close connection for delete request
-	closeConnection := handlePacket(conn, packet, connectId, &bindSuccessful, appConfig)
+	closeConnection := handlePacket(sess, packet, connectId, appConfig)
+	sess.Wait()
 
 	if closeConnection {
 		t.Error("handlePacket should not close connection for delete request")
@@ -156,16 +163,17 @@ func TestHandlePacketUnknownPacket(t *testing.T) {
 	// Create mock connection
 	conn := mocks.NewMockConn()
 	connectId, _ := uuid.NewRandom()
-	bindSuccessful := false
+	sess := ldap.NewSession(conn)
+	sess.BindSuccessful = false
 
 	// Create test configuration
-	appConfig := models.AppConfig{}
+	appConfig := &models.AppConfig{}
 
 	// Create a packet with wrong structure (not 2 children)
 	packet := createMockPacket(5, 0, false) // Only one child
 
 	// Test that handlePacket doesn't close connection for unknown packet
-	closeConnection := handlePacket(conn, packet, connectId, &bindSuccessful, appConfig)
+	closeConnection := handlePacket(sess, packet, connectId, appConfig)
 
 	if closeConnection {
 		t.Error("handlePacket should not close connection for unknown packet")
@@ -176,10 +184,11 @@ func TestHandlePacketUnsupportedOperation(t *testing.T) {
 	// Create mock connection
 	conn := mocks.NewMockConn()
 	connectId, _ := uuid.NewRandom()
-	bindSuccessful := false
+	sess := ldap.NewSession(conn)
+	sess.BindSuccessful = false
 
 	// Create test configuration
-	appConfig := models.AppConfig{}
+	appConfig := &models.AppConfig{}
 
 	// Create a packet with unsupported operation tag
 	packet := createMockPacket(6, 99, true) // Tag 99 = Unsupported
@@ -190,9 +199,141 @@ func TestHandlePacketUnsupportedOperation(t *testing.T) {
 	}
 
 	// Test that handlePacket doesn't close connection for unsupported operation
-	closeConnection := handlePacket(conn, packet, connectId, &bindSuccessful, appConfig)
+	closeConnection := handlePacket(sess, packet, connectId, appConfig)
 
 	if closeConnection {
 		t.Error("handlePacket should not close connection for unsupported operation")
 	}
 }
+
+func TestHandleConnectionOversizedMessage(t *testing.T) {
+	// Message header claiming 2MB of content
+	conn := mocks.NewMockConn().WithReadData([]byte{0x30, 0x83, 0x20, 0x00, 0x00})
+
+	appConfig := &models.AppConfig{
+		Configuration: models.Configuration{MaxReceiveBuffer: 1024},
+	}
+
+	handleConnection(conn, appConfig)
+
+	if !conn.Closed {
+		t.Error("connection should be closed after oversized message")
+	}
+
+	// Client should receive notice of disconnection with protocolError
+	p := ber.DecodePacket(conn.GetWrittenData())
+	if p == nil || len(p.Children) != 2 || p.Children[1].Tag != 0x18 {
+		t.Fatal("handleConnection should send notice of disconnection")
+	}
+	if p.Children[1].Children[0].Value != int64(2) {
+		t.Errorf("notice of disconnection result = %v, want 2", p.Children[1].Children[0].Value)
+	}
+}
+
+func TestHandlePacketInvalidMessageID(t *testing.T) {
+	conn := mocks.NewMockConn()
+	connectId, _ := uuid.NewRandom()
+	sess := ldap.NewSession(conn)
+	sess.BindSuccessful = false
+
+	// Message id 0 is reserved for unsolicited notifications
+	packet := createMockPacket(0, 3, true)
+
+	closeConnection := handlePacket(sess, packet, connectId, &models.AppConfig{})
+
+	if !closeConnection || !conn.Closed {
+		t.Error("handlePacket should close connection on invalid message id")
+	}
+}
+
+// Helper function to encode a complete LDAP message with given message id and operation
+func createEncodedRequest(msgNum int64, op *ber.Packet) []byte {
+	msg := ber.NewSequence("")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgNum, ""))
+	msg.AppendChild(op)
+	return msg.Bytes()
+}
+
+// Helper function to create a search request operation without filter
+func createSearchOperation(baseDN string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 3, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, baseDN, ""))
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, 2, ""))
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, 0, ""))
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 0, ""))
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 0, ""))
+	op.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, false, ""))
+	op.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 7, "objectClass", ""))
+	return op
+}
+
+// Helper function to decode all LDAP messages written to the connection
+func decodeWrittenMessages(t *testing.T, conn *mocks.MockConn) []*ber.Packet {
+	var messages []*ber.Packet
+	reader := bytes.NewReader(conn.GetWrittenData())
+	for reader.Len() > 0 {
+		p, err := ldap.ReadMessage(reader, 0)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		messages = append(messages, p)
+	}
+	return messages
+}
+
+func TestHandleConnectionPipelinedRequests(t *testing.T) {
+	// Two searches sent back-to-back before waiting for any response
+	data := append(createEncodedRequest(7, createSearchOperation("DC=example,DC=com")), createEncodedRequest(300, createSearchOperation("DC=example,DC=com"))...)
+	conn := mocks.NewMockConn().WithReadData(data)
+
+	appConfig := &models.AppConfig{
+		Configuration: models.Configuration{Domain: "example.com"},
+	}
+
+	handleConnection(conn, appConfig)
+
+	// Both searches should be answered (unbound, so only search done messages), in any order
+	messages := decodeWrittenMessages(t, conn)
+	if len(messages) != 2 {
+		t.Fatalf("handleConnection wrote %d messages, want 2", len(messages))
+	}
+
+	answered := make(map[int64]bool)
+	for _, msg := range messages {
+		answered[msg.Children[0].Value.(int64)] = true
+	}
+	if !answered[7] || !answered[300] {
+		t.Errorf("handleConnection should answer both pipelined requests, got %v", answered)
+	}
+}
+
+func TestHandlePacketCriticalControl(t *testing.T) {
+	conn := mocks.NewMockConn()
+	connectId, _ := uuid.NewRandom()
+	sess := ldap.NewSession(conn)
+
+	// Search with unknown critical control
+	control := ber.NewSequence("")
+	control.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "1.2.3.4", ""))
+	control.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, ""))
+	controls := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "")
+	controls.AppendChild(control)
+
+	msg := ber.NewSequence("")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 5, ""))
+	msg.AppendChild(createSearchOperation("DC=example,DC=com"))
+	msg.AppendChild(controls)
+	packet := ber.DecodePacket(msg.Bytes())
+
+	closeConnection := handlePacket(sess, packet, connectId, &models.AppConfig{})
+	sess.Wait()
+
+	if closeConnection {
+		t.Error("handlePacket should not close connection on unsupported control")
+	}
+
+	messages := decodeWrittenMessages(t, conn)
+	if len(messages) != 1 || messages[0].Children[1].Children[0].Value != int64(ldap.ResultUnavailableCriticalExtension) {
+		t.Error("search with unsupported critical control should fail with unavailableCriticalExtension")
+	}
+}
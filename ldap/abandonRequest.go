@@ -0,0 +1,29 @@
+package ldap
+
+import (
+	"log"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Abandon request contains only the message id of operation to abandon. Server never
+// responds to abandon request, nor to the abandoned operation (RFC 4511, section 4.11).
+func HandleAbandonRequest(sess *Session, p *ber.Packet) {
+	if p.Data == nil || p.Data.Len() == 0 {
+		log.Println("Unsupported abandon package")
+		return
+	}
+
+	abandonId, err := ber.ParseInt64(p.Data.Bytes())
+	if err != nil {
+		log.Println("Unsupported abandon package")
+		return
+	}
+
+	// Operation might have completed already, which is fine. Abandoning the latest page of
+	// paged search ends the whole search.
+	if op := sess.findOperation(abandonId); op != nil {
+		op.stop(errAbandoned)
+	}
+	sess.dropPagedSearch(abandonId)
+}
@@ -0,0 +1,87 @@
+package ldap
+
+import (
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to start an operation which runs until it's stopped
+func startBlockingOperation(sess *Session, msgNum int64, tag ber.Tag) *Operation {
+	started := make(chan *Operation)
+	op := NewOperation(msgNum, tag)
+	sess.Run(op, func() {
+		started <- op
+		<-op.ctx.Done()
+		if op.finish() == errCanceled {
+			rsp := createResponsePacket(op.ID)
+			addEndOfSearchPkg(rsp, ResultCanceled, "")
+			sess.Write(rsp.Bytes())
+		}
+	})
+	return <-started
+}
+
+func TestHandleAbandonRequest(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+	op := startBlockingOperation(sess, 5, 3)
+
+	abandonReq := ber.NewInteger(ber.ClassApplication, ber.TypePrimitive, 16, 5, "")
+	HandleAbandonRequest(sess, abandonReq)
+	sess.Wait()
+
+	if !op.stopped() {
+		t.Error("HandleAbandonRequest should stop the operation")
+	}
+
+	// Neither abandon nor abandoned operation is answered
+	if len(conn.GetWrittenData()) != 0 {
+		t.Error("abandoned operation should not send any response")
+	}
+}
+
+func TestHandleAbandonRequestUnknownOperation(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+
+	abandonReq := ber.NewInteger(ber.ClassApplication, ber.TypePrimitive, 16, 99, "")
+	HandleAbandonRequest(sess, abandonReq)
+
+	if len(conn.GetWrittenData()) != 0 {
+		t.Error("abandon request should never be answered")
+	}
+}
+
+func TestHandleSearchRequestAbandoned(t *testing.T) {
+	conn := mocks.NewMockConn()
+	config := createTestConfigWithUsersAndGroups("example.com", nil, []models.Group{createTestGroup("testgroup")})
+	searchReq := createSearchRequestPacket("DC=example,DC=com", "")
+
+	op := NewOperation(1, 3)
+	op.stop(errAbandoned)
+	HandleSearchRequest(createTestSession(conn, true), searchReq, op, config)
+
+	if len(conn.GetWrittenData()) != 0 {
+		t.Error("abandoned search should not send entries or search done")
+	}
+}
+
+func TestHandleSearchRequestCanceled(t *testing.T) {
+	conn := mocks.NewMockConn()
+	config := createTestConfigWithUsersAndGroups("example.com", nil, []models.Group{createTestGroup("testgroup")})
+	searchReq := createSearchRequestPacket("DC=example,DC=com", "")
+
+	op := NewOperation(1, 3)
+	op.stop(errCanceled)
+	HandleSearchRequest(createTestSession(conn, true), searchReq, op, config)
+
+	// Only search done with canceled result code
+	p := ber.DecodePacket(conn.GetWrittenData())
+	if p.Children[1].Tag != 0x05 || p.Children[1].Children[0].Value != int64(ResultCanceled) {
+		t.Error("canceled search should end with canceled result")
+	}
+}
@@ -0,0 +1,321 @@
+package ldap
+
+import (
+	"encoding/binary"
+	"slices"
+	"smad/models"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/google/uuid"
+)
+
+// Attributes maintained by the directory itself, clients can't set them
+var systemAttributes = []string{"memberof", "objectguid", "usncreated", "usnchanged", "isdeleted"}
+
+// Attributes which are given by the name of the object
+var namingAttributes = []string{"objectclass", "cn", "ou", "name", "distinguishedname"}
+
+const (
+	entryExistsMessage          = "00000524: UpdErr: DSID-031A11E2, problem 6005 (ENTRY_EXISTS), data 0"
+	objectClassViolationMessage = "00002014: objectclass: DSID-0310065C, problem 6002 (OBJ_CLASS_VIOLATION), data 0"
+	willNotPerformMessage       = "00002077: SvcErr: DSID-03152D2C, problem 5003 (WILL_NOT_PERFORM), data 0"
+)
+
+// Attribute with its values, as in AddRequest and ModifyRequest
+type partialAttribute struct {
+	name   string
+	values []string
+}
+
+func parsePartialAttribute(p *ber.Packet) (partialAttribute, bool) {
+	if len(p.Children) != 2 {
+		return partialAttribute{}, false
+	}
+
+	attribute := partialAttribute{name: p.Children[0].Data.String()}
+	for _, value := range p.Children[1].Children {
+		attribute.values = append(attribute.values, value.Data.String())
+	}
+	return attribute, attribute.name != ""
+}
+
+func findPartialAttribute(attributes []partialAttribute, name string) *partialAttribute {
+	idx := slices.IndexFunc(attributes, func(attribute partialAttribute) bool { return strings.EqualFold(attribute.name, name) })
+	if idx < 0 {
+		return nil
+	}
+	return &attributes[idx]
+}
+
+// Password is set as UTF-16LE encoded string in quotes, like with AD
+func decodeUnicodePwd(value string) (string, bool) {
+	if len(value)%2 != 0 {
+		return "", false
+	}
+
+	units := make([]uint16, len(value)/2)
+	for idx := range units {
+		units[idx] = binary.LittleEndian.Uint16([]byte(value[2*idx:]))
+	}
+
+	password := string(utf16.Decode(units))
+	if len(password) < 2 || !strings.HasPrefix(password, "\"") || !strings.HasSuffix(password, "\"") {
+		return "", false
+	}
+	return password[1 : len(password)-1], true
+}
+
+// userAccountControl flags which are kept in user's configuration
+func applyUserAccountControl(user *models.User, uac int) {
+	user.UserAccountControl = uac
+	user.Disabled = uac&2 != 0
+	user.PasswordNeverExpire = uac&65536 != 0
+}
+
+func constraintViolationMessage(attribute string) string {
+	return "00002082: AtrErr: DSID-03151943, #1:\n\t0: 00002082: DSID-03151943, problem 1005 (CONSTRAINT_ATT_TYPE), data 0, Att 0 (" + attribute + ")\n"
+}
+
+func invalidSyntaxMessage(attribute string) string {
+	return "00000057: LdapErr: DSID-0C090D8A, comment: Error in attribute conversion operation, data 0, v4563, Att (" + attribute + ")"
+}
+
+// Copies the attributes which have no special meaning to the object. Attributes of objects in
+// the configuration have single value, so multiple values are not allowed.
+func setPlainAttributes(target map[string]string, attributes []partialAttribute, special ...string) ldapResult {
+	for _, attribute := range attributes {
+		name := strings.ToLower(attribute.name)
+		if slices.Contains(namingAttributes, name) || slices.Contains(special, name) {
+			continue
+		}
+		if slices.Contains(systemAttributes, name) {
+			return ldapResult{statusCode: ResultUnwillingToPerform, errorMessage: willNotPerformMessage}
+		}
+		if len(attribute.values) != 1 {
+			return ldapResult{statusCode: ResultConstraintViolation, errorMessage: constraintViolationMessage(attribute.name)}
+		}
+		target[canonicalAttributeName(attribute.name)] = attribute.values[0]
+	}
+	return ldapResult{}
+}
+
+// sAMAccountName is unique among users and groups, like among all security principals in AD.
+// Directory must be locked for reading.
+func samAccountNameTaken(config *models.AppConfig, name string) bool {
+	return name != "" && (slices.ContainsFunc(config.Users, func(c models.User) bool { return strings.EqualFold(samAccountName(c), name) }) ||
+		slices.ContainsFunc(config.Groups, func(c models.Group) bool { return strings.EqualFold(c.Attributes["sAMAccountName"], name) }))
+}
+
+// Creates user from the attributes. Account without password is disabled, unless client sets
+// userAccountControl itself. Directory must be locked for writing.
+func addUser(config *models.AppConfig, cn, container string, attributes []partialAttribute) ldapResult {
+	user := models.User{Cn: cn, Container: container, Attributes: make(map[string]string)}
+	if result := setPlainAttributes(user.Attributes, attributes, "userprincipalname", "unicodepwd", "useraccountcontrol"); result.statusCode != ResultSuccess {
+		return result
+	}
+
+	if upn := findPartialAttribute(attributes, "userPrincipalName"); upn != nil && len(upn.values) > 0 {
+		user.Upn = upn.values[0]
+		if slices.ContainsFunc(config.Users, func(c models.User) bool { return strings.EqualFold(c.Upn, user.Upn) }) {
+			return ldapResult{statusCode: ResultConstraintViolation, errorMessage: constraintViolationMessage(upn.name)}
+		}
+	}
+
+	if pwd := findPartialAttribute(attributes, "unicodePwd"); pwd != nil && len(pwd.values) > 0 {
+		password, ok := decodeUnicodePwd(pwd.values[0])
+		if !ok || password == "" {
+			return ldapResult{statusCode: ResultConstraintViolation, errorMessage: constraintViolationMessage(pwd.name)}
+		}
+		user.Password = password
+	}
+
+	// 512 = normal account, 2 = account disabled
+	uac := 512
+	if user.Password == "" {
+		uac += 2
+	}
+	if attribute := findPartialAttribute(attributes, "userAccountControl"); attribute != nil && len(attribute.values) > 0 {
+		var err error
+		if uac, err = strconv.Atoi(attribute.values[0]); err != nil {
+			return ldapResult{statusCode: ResultInvalidAttributeSyntax, errorMessage: invalidSyntaxMessage(attribute.name)}
+		}
+	}
+	applyUserAccountControl(&user, uac)
+
+	if samAccountNameTaken(config, samAccountName(user)) {
+		return ldapResult{statusCode: ResultEntryAlreadyExists, errorMessage: entryExistsMessage}
+	}
+
+	// Calculated attributes, like for users in the configuration
+	if user.Upn != "" {
+		user.Attributes["userPrincipalName"] = user.Upn
+	}
+	user.Attributes["name"] = user.Cn
+
+	user.ObjectGUID = uuid.NewString()
+	user.USN = newUSN(config)
+	config.Users = append(config.Users, user)
+	notifyChange(config, userElement(config, user))
+
+	return ldapResult{}
+}
+
+// Creates group from the attributes, members given with member attribute must be existing
+// users. Directory must be locked for writing.
+func addGroup(config *models.AppConfig, cn, container string, attributes []partialAttribute) ldapResult {
+	// Users refer to groups by name, so group names must be unique
+	if slices.ContainsFunc(config.Groups, func(c models.Group) bool { return strings.EqualFold(c.Cn, cn) }) {
+		return ldapResult{statusCode: ResultEntryAlreadyExists, errorMessage: entryExistsMessage}
+	}
+
+	group := models.Group{Cn: cn, Container: container, Attributes: make(map[string]string)}
+	if result := setPlainAttributes(group.Attributes, attributes, "member"); result.statusCode != ResultSuccess {
+		return result
+	}
+	if samAccountNameTaken(config, group.Attributes["sAMAccountName"]) {
+		return ldapResult{statusCode: ResultEntryAlreadyExists, errorMessage: entryExistsMessage}
+	}
+
+	var members []int
+	if member := findPartialAttribute(attributes, "member"); member != nil {
+		for _, dn := range member.values {
+			idx := findUserIndex(config, "dn:"+dn)
+			if idx < 0 {
+				return noSuchObjectResult(config, dn)
+			}
+			members = append(members, idx)
+		}
+	}
+
+	group.ObjectGUID = uuid.NewString()
+	group.USN = newUSN(config)
+	config.Groups = append(config.Groups, group)
+
+	for _, idx := range members {
+		if !slices.Contains(config.Users[idx].Groups, cn) {
+			config.Users[idx].Groups = append(config.Users[idx].Groups, cn)
+		}
+	}
+
+	notifyChange(config, groupElement(config, group))
+	return ldapResult{}
+}
+
+// Directory must be locked for writing
+func addOrganizationalUnit(config *models.AppConfig, name, container string, attributes []partialAttribute) ldapResult {
+	ou := models.OrganizationalUnit{Ou: name, Container: container, Attributes: make(map[string]string)}
+	if result := setPlainAttributes(ou.Attributes, attributes); result.statusCode != ResultSuccess {
+		return result
+	}
+
+	ou.ObjectGUID = uuid.NewString()
+	ou.USN = newUSN(config)
+	config.OrganizationalUnits = append(config.OrganizationalUnits, ou)
+	notifyChange(config, ouElement(config, ou))
+
+	return ldapResult{}
+}
+
+// Attributes which client must give for the new object, in addition to its name. AD would
+// generate missing sAMAccountName, but clients creating accounts are expected to set it.
+var requiredAttributes = map[string][]string{
+	"user":  {"sAMAccountName"},
+	"group": {"sAMAccountName"},
+}
+
+// Object class which tells what kind of object is created. Classes which aren't supported
+// return empty class.
+func structuralClass(objectClasses []string) string {
+	for _, class := range []string{"organizationalUnit", "group", "user"} {
+		if slices.ContainsFunc(objectClasses, func(c string) bool { return strings.EqualFold(c, class) }) {
+			return class
+		}
+	}
+	return ""
+}
+
+func addObject(config *models.AppConfig, dn string, attributes []partialAttribute) ldapResult {
+	objectClass := findPartialAttribute(attributes, "objectClass")
+	if objectClass == nil || len(objectClass.values) == 0 {
+		return ldapResult{statusCode: ResultObjectClassViolation, errorMessage: objectClassViolationMessage}
+	}
+
+	class := structuralClass(objectClass.values)
+	if class == "" {
+		return ldapResult{statusCode: ResultUnwillingToPerform, errorMessage: willNotPerformMessage}
+	}
+
+	for _, name := range requiredAttributes[class] {
+		if attribute := findPartialAttribute(attributes, name); attribute == nil || len(attribute.values) == 0 || attribute.values[0] == "" {
+			return ldapResult{statusCode: ResultObjectClassViolation, errorMessage: objectClassViolationMessage}
+		}
+	}
+
+	rdnAttribute, name, parent := splitDN(dn)
+	if name == "" || parent == "" {
+		return ldapResult{statusCode: ResultInvalidDNSyntax, errorMessage: invalidDNSyntaxMessage(dn)}
+	}
+
+	// OUs are named by ou attribute, other objects by cn
+	isOU := class == "organizationalUnit"
+	if (isOU && !strings.EqualFold(rdnAttribute, "OU")) || (!isOU && !strings.EqualFold(rdnAttribute, "CN")) {
+		return ldapResult{statusCode: ResultNamingViolation, errorMessage: namingViolationMessage(parent)}
+	}
+
+	config.Lock()
+	defer config.Unlock()
+
+	if entryExists(config, dn) {
+		return ldapResult{statusCode: ResultEntryAlreadyExists, errorMessage: entryExistsMessage}
+	}
+
+	container, result := resolveContainer(config, parent, isOU)
+	if result.statusCode != ResultSuccess {
+		return result
+	}
+
+	switch class {
+	case "organizationalUnit":
+		return addOrganizationalUnit(config, name, container, attributes)
+	case "group":
+		return addGroup(config, name, container, attributes)
+	default:
+		return addUser(config, name, container, attributes)
+	}
+}
+
+// Adds new user, group or OU to the directory (RFC 4511, section 4.7). Attributes which aren't
+// used by the server itself are stored as they are.
+func HandleAddRequest(sess *Session, p *ber.Packet, op *Operation, config *models.AppConfig) {
+	if len(p.Children) != 2 {
+		sendResult(sess, op.ID, 0x09, ldapResult{statusCode: ResultProtocolError, errorMessage: DecodingErrorMessage})
+		return
+	}
+
+	if !sess.BindSuccessful {
+		sendResult(sess, op.ID, 0x09, ldapResult{statusCode: ResultInsufficientAccessRights, errorMessage: insufficientAccessMessage})
+		return
+	}
+
+	dn := p.Children[0].Data.String()
+	var attributes []partialAttribute
+	for _, child := range p.Children[1].Children {
+		attribute, ok := parsePartialAttribute(child)
+		if !ok {
+			sendResult(sess, op.ID, 0x09, ldapResult{statusCode: ResultProtocolError, errorMessage: DecodingErrorMessage})
+			return
+		}
+		attributes = append(attributes, attribute)
+	}
+
+	// Once the directory is changed, it's too late to stop
+	if err := op.finish(); err != nil {
+		sendStoppedResult(sess, op.ID, 0x09, err)
+		return
+	}
+
+	sendResult(sess, op.ID, 0x09, addObject(config, dn, attributes))
+}
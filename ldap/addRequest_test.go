@@ -0,0 +1,288 @@
+package ldap
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+	"unicode/utf16"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to create add request packet
+func createAddRequestPacket(dn string, attributes map[string][]string) *ber.Packet {
+	addReq := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 0x08, nil, "")
+	addReq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+
+	attributesPkg := ber.NewSequence("")
+	for name, values := range attributes {
+		attributePkg := ber.NewSequence("")
+		attributePkg.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		valuesPkg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, value := range values {
+			valuesPkg.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		}
+		attributePkg.AppendChild(valuesPkg)
+		attributesPkg.AppendChild(attributePkg)
+	}
+	addReq.AppendChild(attributesPkg)
+	return addReq
+}
+
+// Helper function to encode password like clients do for unicodePwd attribute
+func encodeUnicodePwd(password string) string {
+	var encoded []byte
+	for _, unit := range utf16.Encode([]rune("\"" + password + "\"")) {
+		encoded = binary.LittleEndian.AppendUint16(encoded, unit)
+	}
+	return string(encoded)
+}
+
+// Helper function to run add request, returns result code and matched DN
+func addEntry(t *testing.T, config *models.AppConfig, bound bool, dn string, attributes map[string][]string) (int64, string) {
+	conn := mocks.NewMockConn()
+	HandleAddRequest(createTestSession(conn, bound), createAddRequestPacket(dn, attributes), NewOperation(1, 8), config)
+
+	messages := decodeMessages(t, conn)
+	if len(messages) != 1 {
+		t.Fatalf("expected one response, got %d", len(messages))
+	}
+	return messages[0].Children[1].Children[0].Value.(int64), messages[0].Children[1].Children[1].Value.(string)
+}
+
+func TestAddUser(t *testing.T) {
+	config := createTestConfigWithUsersAndGroups("example.com", nil, []models.Group{createTestGroup("group1")})
+
+	code, _ := addEntry(t, config, true, "CN=New User,CN=Users,DC=example,DC=com", map[string][]string{
+		"objectClass":       {"top", "person", "organizationalPerson", "user"},
+		"sAMAccountName":    {"new.user"},
+		"userPrincipalName": {"new.user@example.com"},
+		"unicodePwd":        {encodeUnicodePwd("secret")},
+		"givenName":         {"New"},
+	})
+	if code != ResultSuccess {
+		t.Fatalf("adding user returned %d", code)
+	}
+
+	if len(config.Users) != 1 {
+		t.Fatalf("expected user to be added, got %d users", len(config.Users))
+	}
+	user := config.Users[0]
+	if user.Cn != "New User" || user.Upn != "new.user@example.com" || user.Password != "secret" || user.Attributes["givenName"] != "New" {
+		t.Errorf("user has wrong values: %+v", user)
+	}
+	if user.UserAccountControl != 512 || user.Disabled || user.ObjectGUID == "" || user.USNCreated == 0 {
+		t.Errorf("user should be enabled normal account with GUID and USN: %+v", user)
+	}
+
+	// User can bind with the new account
+	conn := mocks.NewMockConn()
+	if !HandleBindRequest(NewSession(conn), createLDAPMessageWithBindRequest("new.user@example.com", "secret", 1).Children[1], 1, config) {
+		t.Error("new user should be able to bind")
+	}
+}
+
+func TestAddUserWithoutPasswordIsDisabled(t *testing.T) {
+	config := createTestConfig("example.com")
+
+	if code, _ := addEntry(t, config, true, "CN=nopass,CN=Users,DC=example,DC=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"nopass"}}); code != ResultSuccess {
+		t.Fatalf("adding user returned %d", code)
+	}
+	if !config.Users[0].Disabled || config.Users[0].UserAccountControl != 514 {
+		t.Errorf("user without password should be disabled, got uac %d", config.Users[0].UserAccountControl)
+	}
+}
+
+func TestAddGroupWithMembers(t *testing.T) {
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("user1", "user1@example.com", "pass", nil, nil),
+	}, nil)
+
+	code, _ := addEntry(t, config, true, "CN=developers,CN=Users,DC=example,DC=com", map[string][]string{
+		"objectClass":    {"top", "group"},
+		"member":         {"cn=user1,cn=users,dc=example,dc=com"},
+		"sAMAccountName": {"developers"},
+	})
+	if code != ResultSuccess {
+		t.Fatalf("adding group returned %d", code)
+	}
+
+	if len(config.Groups) != 1 || config.Groups[0].Attributes["sAMAccountName"] != "developers" {
+		t.Fatalf("group should be added with its attributes, got %+v", config.Groups)
+	}
+	if !slices.Equal(config.Users[0].Groups, []string{"developers"}) {
+		t.Errorf("member should be added to the group, got %v", config.Users[0].Groups)
+	}
+
+	// Member must exist
+	code, matchedDN := addEntry(t, config, true, "CN=testers,CN=Users,DC=example,DC=com", map[string][]string{
+		"objectClass":    {"group"},
+		"member":         {"CN=nobody,CN=Users,DC=example,DC=com"},
+		"sAMAccountName": {"testers"},
+	})
+	if code != ResultNoSuchObject || matchedDN != "CN=Users,DC=example,DC=com" {
+		t.Errorf("group with missing member returned %d (%s), want noSuchObject", code, matchedDN)
+	}
+}
+
+func TestAddOrganizationalUnit(t *testing.T) {
+	config := createTestConfig("example.com")
+
+	if code, _ := addEntry(t, config, true, "OU=Staff,DC=example,DC=com", map[string][]string{"objectClass": {"organizationalUnit"}}); code != ResultSuccess {
+		t.Fatalf("adding OU returned %d", code)
+	}
+	if code, _ := addEntry(t, config, true, "OU=Helsinki,OU=Staff,DC=example,DC=com", map[string][]string{"objectClass": {"organizationalUnit"}}); code != ResultSuccess {
+		t.Fatalf("adding nested OU returned %d", code)
+	}
+	if code, _ := addEntry(t, config, true, "CN=user1,OU=Helsinki,OU=Staff,DC=example,DC=com", map[string][]string{
+		"objectClass":       {"user"},
+		"sAMAccountName":    {"user1"},
+		"userPrincipalName": {"user1@example.com"},
+	}); code != ResultSuccess {
+		t.Fatalf("adding user to OU returned %d", code)
+	}
+
+	names := searchNames(t, config)
+	expected := []string{"DC=example,DC=com", "CN=Users,DC=example,DC=com", "OU=Staff,DC=example,DC=com", "OU=Helsinki,OU=Staff,DC=example,DC=com", "CN=user1,OU=Helsinki,OU=Staff,DC=example,DC=com"}
+	if !slices.Equal(names, expected) {
+		t.Errorf("search returned %v, want %v", names, expected)
+	}
+}
+
+func TestAddUnderDomain(t *testing.T) {
+	config := createTestConfig("example.com")
+
+	if code, _ := addEntry(t, config, true, "CN=rootuser,DC=example,DC=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"rootuser"}}); code != ResultSuccess {
+		t.Fatalf("adding user under domain returned %d", code)
+	}
+	if code, _ := addEntry(t, config, true, "CN=rootgroup,DC=example,DC=com", map[string][]string{
+		"objectClass":    {"group"},
+		"sAMAccountName": {"rootgroup"},
+		"member":         {"CN=rootuser,DC=example,DC=com"},
+	}); code != ResultSuccess {
+		t.Fatalf("adding group under domain returned %d", code)
+	}
+
+	names := searchNames(t, config)
+	expected := []string{"DC=example,DC=com", "CN=Users,DC=example,DC=com", "CN=rootgroup,DC=example,DC=com", "CN=rootuser,DC=example,DC=com"}
+	if !slices.Equal(names, expected) {
+		t.Errorf("search returned %v, want %v", names, expected)
+	}
+
+	// Objects under domain can be moved to CN=Users and back
+	if code := modifyDNEntry(t, config, "CN=rootuser,DC=example,DC=com", "CN=rootuser", "CN=Users,DC=example,DC=com"); code != ResultSuccess {
+		t.Errorf("moving user to CN=Users returned %d", code)
+	}
+	if code := modifyDNEntry(t, config, "CN=rootuser,CN=Users,DC=example,DC=com", "CN=rootuser", "DC=example,DC=com"); code != ResultSuccess {
+		t.Errorf("moving user back under domain returned %d", code)
+	}
+	if code := deleteEntry(t, config, true, "CN=rootuser,DC=example,DC=com"); code != ResultSuccess {
+		t.Errorf("deleting user under domain returned %d", code)
+	}
+}
+
+func TestAddLowercaseAttributeName(t *testing.T) {
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("user1", "user1@example.com", "pass", nil, map[string]string{"sAMAccountName": "jdoe"}),
+	}, nil)
+
+	if code, _ := addEntry(t, config, true, "CN=user2,CN=Users,DC=example,DC=com", map[string][]string{"objectclass": {"user"}, "samaccountname": {"JDOE"}}); code != ResultEntryAlreadyExists {
+		t.Errorf("adding user with lowercase sAMAccountName of existing user returned %d, want entryAlreadyExists", code)
+	}
+
+	if code, _ := addEntry(t, config, true, "CN=user3,CN=Users,DC=example,DC=com", map[string][]string{"objectclass": {"user"}, "samaccountname": {"jsmith"}, "GIVENNAME": {"John"}}); code != ResultSuccess {
+		t.Fatalf("adding user with lowercase attribute names returned %d", code)
+	}
+	if user := config.Users[1]; user.Attributes["sAMAccountName"] != "jsmith" || user.Attributes["givenName"] != "John" || samAccountName(user) != "jsmith" {
+		t.Errorf("attributes should be stored with schema names, got %v", user.Attributes)
+	}
+}
+
+func TestAddDuplicateSAMAccountName(t *testing.T) {
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("user1", "user1@example.com", "pass", nil, map[string]string{"sAMAccountName": "jdoe"}),
+	}, nil)
+	config.OrganizationalUnits = []models.OrganizationalUnit{{Ou: "Staff"}}
+
+	if code, _ := addEntry(t, config, true, "CN=Staff Group,OU=Staff,DC=example,DC=com", map[string][]string{"objectClass": {"group"}, "sAMAccountName": {"staff"}}); code != ResultSuccess {
+		t.Fatalf("adding group returned %d", code)
+	}
+
+	tests := []struct {
+		name           string
+		dn             string
+		class          string
+		samAccountName string
+	}{
+		{"group with name of user", "CN=jdoe group,CN=Users,DC=example,DC=com", "group", "JDOE"},
+		{"group with name of group in other container", "CN=Staff,CN=Users,DC=example,DC=com", "group", "staff"},
+		{"user with name of group", "CN=staff user,CN=Users,DC=example,DC=com", "user", "Staff"},
+	}
+
+	for _, tt := range tests {
+		if code, _ := addEntry(t, config, true, tt.dn, map[string][]string{"objectClass": {tt.class}, "sAMAccountName": {tt.samAccountName}}); code != ResultEntryAlreadyExists {
+			t.Errorf("%s: add returned %d, want entryAlreadyExists", tt.name, code)
+		}
+	}
+}
+
+func TestAddEscapedName(t *testing.T) {
+	config := createTestConfigWithUsersAndGroups("example.com", nil, []models.Group{createTestGroup("group1")})
+
+	if code, _ := addEntry(t, config, true, `CN=Doe\, John,CN=Users,DC=example,DC=com`, map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"john.doe"}}); code != ResultSuccess {
+		t.Fatalf("adding user with escaped name returned %d", code)
+	}
+	if len(config.Users) != 1 || config.Users[0].Cn != "Doe, John" {
+		t.Fatalf("user should be named without escapes, got %+v", config.Users)
+	}
+
+	// Same name escaped differently already exists
+	if code, _ := addEntry(t, config, true, `CN=Doe\2C John,CN=Users,DC=example,DC=com`, map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"doe.john"}}); code != ResultEntryAlreadyExists {
+		t.Errorf("adding user with hex escaped name returned %d, want entryAlreadyExists", code)
+	}
+
+	if names := searchNames(t, config); !slices.Contains(names, `CN=Doe\, John,CN=Users,DC=example,DC=com`) {
+		t.Errorf("search should return escaped name, got %v", names)
+	}
+}
+
+func TestAddErrors(t *testing.T) {
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("user1", "user1@example.com", "pass", nil, nil),
+	}, nil)
+
+	tests := []struct {
+		name       string
+		bound      bool
+		dn         string
+		attributes map[string][]string
+		expected   int64
+	}{
+		{"unbound", false, "CN=user2,CN=Users,DC=example,DC=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"user2"}}, ResultInsufficientAccessRights},
+		{"existing entry", true, "cn=USER1,CN=Users,DC=example,DC=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"user2"}}, ResultEntryAlreadyExists},
+		{"built-in container", true, "CN=Users,DC=example,DC=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"user2"}}, ResultEntryAlreadyExists},
+		{"configuration container", true, "CN=Configuration,DC=example,DC=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"user2"}}, ResultEntryAlreadyExists},
+		{"deleted objects container", true, "CN=Deleted Objects,DC=example,DC=com", map[string][]string{"objectClass": {"group"}, "sAMAccountName": {"group2"}}, ResultEntryAlreadyExists},
+		{"missing parent", true, "CN=user2,OU=Missing,DC=example,DC=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"user2"}}, ResultNoSuchObject},
+		{"missing object class", true, "CN=user2,CN=Users,DC=example,DC=com", map[string][]string{"givenName": {"Two"}}, ResultObjectClassViolation},
+		{"wrong naming attribute", true, "OU=user2,CN=Users,DC=example,DC=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"user2"}}, ResultNamingViolation},
+		{"multiple values", true, "CN=user2,CN=Users,DC=example,DC=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"user2"}, "sn": {"a", "b"}}, ResultConstraintViolation},
+		{"system attribute", true, "CN=user2,CN=Users,DC=example,DC=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"user2"}, "memberOf": {"CN=group1,CN=Users,DC=example,DC=com"}}, ResultUnwillingToPerform},
+		{"missing sAMAccountName", true, "CN=user2,CN=Users,DC=example,DC=com", map[string][]string{"objectClass": {"user"}}, ResultObjectClassViolation},
+		{"group without sAMAccountName", true, "CN=group2,CN=Users,DC=example,DC=com", map[string][]string{"objectClass": {"group"}}, ResultObjectClassViolation},
+		{"duplicate upn", true, "CN=user2,CN=Users,DC=example,DC=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"user2"}, "userPrincipalName": {"USER1@example.com"}}, ResultConstraintViolation},
+	}
+
+	for _, tt := range tests {
+		if code, _ := addEntry(t, config, tt.bound, tt.dn, tt.attributes); code != tt.expected {
+			t.Errorf("%s: add returned %d, want %d", tt.name, code, tt.expected)
+		}
+	}
+
+	if len(config.Users) != 1 {
+		t.Errorf("failed adds should not change the directory, got %d users", len(config.Users))
+	}
+}
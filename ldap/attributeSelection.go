@@ -0,0 +1,91 @@
+package ldap
+
+import (
+	"slices"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Operational attributes are returned only when client asks for them by name or with '+'
+// (RFC 3673). Names are in lower case.
+var operationalAttributes = []string{"canonicalname", "subschemasubentry"}
+
+// Reads requested attributes, their value ranges and typesOnly flag from search request
+// (RFC 4511, section 4.5.1.8). Empty list and '*' select all user attributes, and '1.1' alone
+// selects none of them.
+func parseEntryOptions(p *ber.Packet, maxValRange int) entryOptions {
+	options := entryOptions{maxValRange: maxValRange}
+	if len(p.Children) > 5 {
+		options.typesOnly, _ = p.Children[5].Value.(bool)
+	}
+	if len(p.Children) < 8 {
+		return options
+	}
+
+	// Client can ask for part of the values with 'member;range=1500-*' style attributes
+	options.ranges = parseRequestedRanges(p.Children[7])
+
+	allUserAttributes := false
+	for _, attribute := range p.Children[7].Children {
+		name, _, _ := strings.Cut(strings.ToLower(attribute.Data.String()), ";")
+
+		switch name {
+		case "*":
+			allUserAttributes = true
+		case "+":
+			options.operational = true
+		case "1.1":
+		default:
+			if options.attributes == nil {
+				options.attributes = make(map[string]bool)
+			}
+			options.attributes[name] = true
+		}
+		options.onlyRequested = true
+	}
+
+	if allUserAttributes {
+		options.onlyRequested = false
+	}
+	return options
+}
+
+// Should the attribute be included in the search result entry?
+func (o entryOptions) selected(attrType string) bool {
+	name := strings.ToLower(attrType)
+	if o.attributes[name] {
+		return true
+	}
+	if slices.Contains(operationalAttributes, name) {
+		return o.operational
+	}
+	return !o.onlyRequested
+}
+
+// Domain of distinguished name, like 'example.com'
+func domainOfDN(dn string) string {
+	var domainParts []string
+	for rest := dn; rest != ""; {
+		attr, value, parent := splitDN(rest)
+		if strings.EqualFold(attr, "DC") {
+			domainParts = append(domainParts, value)
+		}
+		rest = parent
+	}
+	return strings.Join(domainParts, ".")
+}
+
+// Constructed canonical name of the object, like 'example.com/Users/user1'
+func canonicalName(dn string) string {
+	var path []string
+	for rest := dn; rest != ""; {
+		attr, value, parent := splitDN(rest)
+		if !strings.EqualFold(attr, "DC") {
+			path = append([]string{value}, path...)
+		}
+		rest = parent
+	}
+
+	return domainOfDN(dn) + "/" + strings.Join(path, "/")
+}
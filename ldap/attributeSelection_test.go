@@ -0,0 +1,123 @@
+package ldap
+
+import (
+	"slices"
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to search the only user with given attribute list. Returns attributes of the
+// entry by name.
+func searchUserAttributes(t *testing.T, typesOnly bool, attributes ...string) map[string][]string {
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("user1", "user1@example.com", "pass", nil, map[string]string{"givenName": "One", "sn": "User"}),
+	}, nil)
+	config.Users[0].UserAccountControl = 512
+
+	searchReq := createSearchRequestPacket("CN=user1,CN=Users,DC=example,DC=com", "")
+	searchReq.Children[5] = ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, typesOnly, "")
+	attributesPkg := ber.NewSequence("")
+	for _, attribute := range attributes {
+		attributesPkg.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute, ""))
+	}
+	searchReq.AppendChild(attributesPkg)
+
+	conn := mocks.NewMockConn()
+	HandleSearchRequest(createTestSession(conn, true), searchReq, NewOperation(1, 3), config)
+
+	messages := decodeMessages(t, conn)
+	if len(messages) != 2 {
+		t.Fatalf("expected user entry and end of search, got %d messages", len(messages))
+	}
+
+	return entryAttributes(messages[0])
+}
+
+// Helper function to decode attributes of search result entry message, by name
+func entryAttributes(msg *ber.Packet) map[string][]string {
+	found := make(map[string][]string)
+	for _, attribute := range msg.Children[1].Children[1].Children {
+		var values []string
+		for _, value := range attribute.Children[1].Children {
+			values = append(values, value.Data.String())
+		}
+		found[attribute.Children[0].Data.String()] = values
+	}
+	return found
+}
+
+func attributeNames(attributes map[string][]string) []string {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestAttributeSelection(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes []string
+		expected   []string
+	}{
+		{"by name", []string{"GIVENNAME", "cn"}, []string{"cn", "givenName"}},
+		{"with range option", []string{"sn;range=0-*"}, []string{"sn"}},
+		{"no attributes", []string{"1.1"}, []string{}},
+		{"no attributes with name", []string{"1.1", "sn"}, []string{"sn"}},
+		{"operational", []string{"+"}, []string{"canonicalName", "subschemaSubentry"}},
+		{"operational by name", []string{"canonicalname", "sn"}, []string{"canonicalName", "sn"}},
+	}
+
+	for _, tt := range tests {
+		if names := attributeNames(searchUserAttributes(t, false, tt.attributes...)); !slices.Equal(names, tt.expected) {
+			t.Errorf("%s: search returned %v, want %v", tt.name, names, tt.expected)
+		}
+	}
+}
+
+func TestAttributeSelectionAllUserAttributes(t *testing.T) {
+	all := searchUserAttributes(t, false)
+	if _, ok := all["canonicalName"]; ok || len(all["userAccountControl"]) != 1 || len(all["objectClass"]) != 4 {
+		t.Errorf("search without attributes should return all user attributes, got %v", attributeNames(all))
+	}
+
+	if withStar := searchUserAttributes(t, false, "*"); !slices.Equal(attributeNames(withStar), attributeNames(all)) {
+		t.Errorf("'*' should return all user attributes, got %v", attributeNames(withStar))
+	}
+
+	withOperational := searchUserAttributes(t, false, "*", "+")
+	if !slices.Equal(withOperational["canonicalName"], []string{"example.com/Users/user1"}) || len(withOperational) != len(all)+2 {
+		t.Errorf("'*' and '+' should return all attributes, got %v", attributeNames(withOperational))
+	}
+}
+
+func TestTypesOnly(t *testing.T) {
+	attributes := searchUserAttributes(t, true, "sn", "objectClass")
+	if !slices.Equal(attributeNames(attributes), []string{"objectClass", "sn"}) {
+		t.Fatalf("search returned %v", attributeNames(attributes))
+	}
+	for name, values := range attributes {
+		if len(values) != 0 {
+			t.Errorf("typesOnly search returned values %v for %s", values, name)
+		}
+	}
+}
+
+func TestCanonicalName(t *testing.T) {
+	tests := map[string]string{
+		"DC=example,DC=com":                               "example.com/",
+		"CN=Users,DC=example,DC=com":                      "example.com/Users",
+		"CN=user1,OU=Helsinki,OU=Staff,DC=example,DC=com": "example.com/Staff/Helsinki/user1",
+	}
+
+	for dn, expected := range tests {
+		if name := canonicalName(dn); name != expected {
+			t.Errorf("canonicalName(%s) = %s, want %s", dn, name, expected)
+		}
+	}
+}
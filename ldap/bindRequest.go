@@ -3,7 +3,6 @@ package ldap
 import (
 	"fmt"
 	"log"
-	"net"
 	"slices"
 	"smad/models"
 	"strings"
@@ -11,7 +10,11 @@ import (
 	ber "github.com/go-asn1-ber/asn1-ber"
 )
 
-func HandleBindRequest(conn net.Conn, p *ber.Packet, msgNum uint8, users []models.User) bool {
+func HandleBindRequest(sess *Session, p *ber.Packet, msgNum int64, config *models.AppConfig) bool {
+	// Connection is anonymous until the bind succeeds, even if it was bound before
+	sess.BindSuccessful = false
+	sess.BindUser = ""
+
 	if len(p.Children) != 3 {
 		log.Println("Unsupported bind package")
 		return false
@@ -30,6 +33,10 @@ func HandleBindRequest(conn net.Conn, p *ber.Packet, msgNum uint8, users []model
 	user = strings.ToLower(user)
 	password := fmt.Sprintf("%v", p.Children[2].Data)
 
+	config.RLock()
+	defer config.RUnlock()
+	users := config.Users
+
 	// See if we can find the user
 	userRecordIdx := slices.IndexFunc(users, func(c models.User) bool { return strings.ToLower(c.Upn) == user })
 
@@ -74,7 +81,12 @@ func HandleBindRequest(conn net.Conn, p *ber.Packet, msgNum uint8, users []model
 	rsp.AppendChild(bindRspPacket)
 
 	// Finally transmit the response to client
-	conn.Write(rsp.Bytes())
+	sess.Write(rsp.Bytes())
+
+	if userOk {
+		sess.BindSuccessful = true
+		sess.BindUser = users[userRecordIdx].ObjectGUID
+	}
 
 	return userOk
 }
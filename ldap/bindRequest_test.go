@@ -10,7 +10,7 @@ import (
 )
 
 // Helper function to create a proper LDAP message packet containing a bind request
-func createLDAPMessageWithBindRequest(username, password string, msgNum uint8) *ber.Packet {
+func createLDAPMessageWithBindRequest(username, password string, msgNum int64) *ber.Packet {
 	// Create the main LDAP message packet
 	mainPacket := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ber.TagSequence, nil, "")
 
@@ -57,7 +57,7 @@ func TestHandleBindRequestSuccessful(t *testing.T) {
 	mainPacket := createLDAPMessageWithBindRequest("testuser@example.com", "correctpassword", 1)
 
 	// Call HandleBindRequest with the bind request packet (mainPacket.Children[1])
-	result := HandleBindRequest(conn, mainPacket.Children[1], 1, users)
+	result := HandleBindRequest(NewSession(conn), mainPacket.Children[1], 1, &models.AppConfig{Users: users})
 
 	// Verify the result
 	if !result {
@@ -89,7 +89,7 @@ func TestHandleBindRequestDisabledAccount(t *testing.T) {
 	mainPacket := createLDAPMessageWithBindRequest("disableduser@example.com", "password123", 2)
 
 	// Call HandleBindRequest with the bind request packet (mainPacket.Children[1])
-	result := HandleBindRequest(conn, mainPacket.Children[1], 2, users)
+	result := HandleBindRequest(NewSession(conn), mainPacket.Children[1], 2, &models.AppConfig{Users: users})
 
 	// Verify the result
 	if result {
@@ -126,7 +126,7 @@ func TestHandleBindRequestWrongPassword(t *testing.T) {
 	mainPacket := createLDAPMessageWithBindRequest("testuser@example.com", "wrongpassword", 3)
 
 	// Call HandleBindRequest with the bind request packet (mainPacket.Children[1])
-	result := HandleBindRequest(conn, mainPacket.Children[1], 3, users)
+	result := HandleBindRequest(NewSession(conn), mainPacket.Children[1], 3, &models.AppConfig{Users: users})
 
 	// Verify the result
 	if result {
@@ -157,7 +157,7 @@ func TestHandleBindRequestUserNotFound(t *testing.T) {
 	mainPacket := createLDAPMessageWithBindRequest("nonexistent@example.com", "somepassword", 4)
 
 	// Call HandleBindRequest with the bind request packet (mainPacket.Children[1])
-	result := HandleBindRequest(conn, mainPacket.Children[1], 4, users)
+	result := HandleBindRequest(NewSession(conn), mainPacket.Children[1], 4, &models.AppConfig{Users: users})
 
 	// Verify the result
 	if result {
@@ -193,10 +193,39 @@ func TestHandleBindRequestCaseInsensitive(t *testing.T) {
 	mainPacket := createLDAPMessageWithBindRequest("testuser@example.com", "TestPassword123", 5)
 
 	// Call HandleBindRequest with the bind request packet (mainPacket.Children[1])
-	result := HandleBindRequest(conn, mainPacket.Children[1], 5, users)
+	result := HandleBindRequest(NewSession(conn), mainPacket.Children[1], 5, &models.AppConfig{Users: users})
 
 	// Verify the result
 	if !result {
 		t.Error("HandleBindRequest should be case-insensitive for usernames")
 	}
 }
+
+func TestHandleBindRequestSessionState(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+
+	users := []models.User{
+		{
+			Upn:        "testuser@example.com",
+			Password:   "correctpassword",
+			ObjectGUID: "0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f",
+		},
+	}
+
+	// Successful bind stores the bound user
+	mainPacket := createLDAPMessageWithBindRequest("TESTUSER@example.com", "correctpassword", 1)
+	HandleBindRequest(sess, mainPacket.Children[1], 1, &models.AppConfig{Users: users})
+
+	if !sess.BindSuccessful || sess.BindUser != "0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f" {
+		t.Errorf("session should be bound by objectGUID of testuser@example.com, got '%s'", sess.BindUser)
+	}
+
+	// Failed bind returns the connection to anonymous state
+	mainPacket = createLDAPMessageWithBindRequest("testuser@example.com", "wrongpassword", 2)
+	HandleBindRequest(sess, mainPacket.Children[1], 2, &models.AppConfig{Users: users})
+
+	if sess.BindSuccessful || sess.BindUser != "" {
+		t.Error("failed bind should reset session to anonymous")
+	}
+}
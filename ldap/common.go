@@ -1,10 +1,117 @@
 package ldap
 
-import ber "github.com/go-asn1-ber/asn1-ber"
+import (
+	"errors"
+	"math"
+	"net"
 
-func createResponsePacket(msgNum uint8) *ber.Packet {
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// LDAP result codes (RFC 4511, appendix A)
+const (
+	ResultSuccess                      = 0
+	ResultOperationsError              = 1
+	ResultProtocolError                = 2
+	ResultTimeLimitExceeded            = 3
+	ResultSizeLimitExceeded            = 4
+	ResultCompareFalse                 = 5
+	ResultCompareTrue                  = 6
+	ResultAdminLimitExceeded           = 11
+	ResultUnavailableCriticalExtension = 12
+	ResultNoSuchAttribute              = 16
+	ResultInappropriateMatching        = 18
+	ResultConstraintViolation          = 19
+	ResultAttributeOrValueExists       = 20
+	ResultInvalidAttributeSyntax       = 21
+	ResultNoSuchObject                 = 32
+	ResultInvalidDNSyntax              = 34
+	ResultInsufficientAccessRights     = 50
+	ResultUnavailable                  = 52
+	ResultUnwillingToPerform           = 53
+	ResultSortControlMissing           = 60
+	ResultOffsetRangeError             = 61
+	ResultNamingViolation              = 64
+	ResultObjectClassViolation         = 65
+	ResultNotAllowedOnNonLeaf          = 66
+	ResultNotAllowedOnRDN              = 67
+	ResultEntryAlreadyExists           = 68
+	ResultCanceled                     = 118
+	ResultNoSuchOperation              = 119
+	ResultTooLate                      = 120
+)
+
+const noticeOfDisconnectionOID = "1.3.6.1.4.1.1466.20036"
+
+const DecodingErrorMessage = "00000057: LdapErr: DSID-0C090D8A, comment: Error decoding ldap message, data 0, v4563"
+
+const insufficientAccessMessage = "00002098: SecErr: DSID-03150BC1, problem 4003 (INSUFF_ACCESS_RIGHTS), data 0"
+
+const bindRequiredMessage = "000004DC: LdapErr: DSID-0C090CF4, comment: In order to perform this operation a successful bind must be completed on the connection., data 0, v4563"
+
+// Parses messageID of LDAPMessage. Message ids are full integers in range 0..2^31-1, where 0
+// is reserved for unsolicited notifications (RFC 4511, section 4.1.1.1)
+func ParseMessageID(p *ber.Packet) (int64, error) {
+	if p.ClassType != ber.ClassUniversal || p.Tag != ber.TagInteger {
+		return 0, errors.New("message id is not an integer")
+	}
+
+	msgNum, err := ber.ParseInt64(p.ByteValue)
+	if err != nil {
+		return 0, err
+	}
+
+	if msgNum <= 0 || msgNum > math.MaxInt32 {
+		return 0, errors.New("message id out of range")
+	}
+
+	return msgNum, nil
+}
+
+func createResponsePacket(msgNum int64) *ber.Packet {
 	rsp := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
 	msgNumPacket := ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgNum, "")
 	rsp.AppendChild(msgNumPacket)
 	return rsp
 }
+
+// Outcome of an operation, which is sent to the client as LDAPResult
+type ldapResult struct {
+	statusCode   int
+	matchedDN    string
+	errorMessage string
+}
+
+// Creates LDAPResult structure, which all responses (except search entries) are built on
+func createResultPkg(tag ber.Tag, statusCode int, matchedDN, errorMessage string) *ber.Packet {
+	resultPacket := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	codePacket := ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, statusCode, "")
+	resultPacket.AppendChild(codePacket)
+	dnPacket := ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, matchedDN, "")
+	resultPacket.AppendChild(dnPacket)
+	msgPacket := ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, errorMessage, "")
+	resultPacket.AppendChild(msgPacket)
+	return resultPacket
+}
+
+func sendResult(sess *Session, msgNum int64, tag ber.Tag, result ldapResult) {
+	rsp := createResponsePacket(msgNum)
+	rsp.AppendChild(createResultPkg(tag, result.statusCode, result.matchedDN, result.errorMessage))
+	sess.Write(rsp.Bytes())
+}
+
+// Operation which was stopped before completing: abandoned operation is never answered,
+// canceled one tells the client it was canceled
+func sendStoppedResult(sess *Session, msgNum int64, tag ber.Tag, err error) {
+	if err == errCanceled {
+		sendResult(sess, msgNum, tag, ldapResult{statusCode: ResultCanceled})
+	}
+}
+
+// Sends unsolicited notification (message id 0) telling the client that server is about to
+// close the connection (RFC 4511, section 4.4.1)
+func SendNoticeOfDisconnection(conn net.Conn, statusCode int, errorMessage string) {
+	rsp := createResponsePacket(0)
+	rsp.AppendChild(createExtendedResponsePkg(statusCode, errorMessage, noticeOfDisconnectionOID, nil))
+	conn.Write(rsp.Bytes())
+}
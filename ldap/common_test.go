@@ -0,0 +1,66 @@
+package ldap
+
+import (
+	"bytes"
+	"testing"
+
+	"smad/internal/mocks"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to decode all LDAP messages written to the connection
+func decodeMessages(t *testing.T, conn *mocks.MockConn) []*ber.Packet {
+	var messages []*ber.Packet
+	reader := bytes.NewReader(conn.GetWrittenData())
+	for reader.Len() > 0 {
+		p, err := ReadMessage(reader, 0)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		messages = append(messages, p)
+	}
+	return messages
+}
+
+func TestParseMessageID(t *testing.T) {
+	// Multi-byte message ids must be decoded as full integers
+	for _, expected := range []int64{1, 255, 256, 70000, 2147483647} {
+		p := ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, expected, "")
+		p = ber.DecodePacket(p.Bytes())
+
+		result, err := ParseMessageID(p)
+		if err != nil || result != expected {
+			t.Errorf("ParseMessageID() = %d, %v, want %d", result, err, expected)
+		}
+	}
+
+	// Zero, negative and too large values are not valid request ids
+	for _, invalid := range []int64{0, -1, 2147483648} {
+		p := ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, invalid, "")
+		p = ber.DecodePacket(p.Bytes())
+
+		if _, err := ParseMessageID(p); err == nil {
+			t.Errorf("ParseMessageID() should fail for %d", invalid)
+		}
+	}
+
+	// Message id must be an integer
+	p := ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "1", "")
+	if _, err := ParseMessageID(p); err == nil {
+		t.Error("ParseMessageID() should fail for non-integer packet")
+	}
+}
+
+func TestResponseCarriesFullMessageID(t *testing.T) {
+	conn := mocks.NewMockConn()
+	config := createTestConfig("example.com")
+	searchReq := createSearchRequestPacket("DC=example,DC=com", "")
+
+	HandleSearchRequest(createTestSession(conn, false), searchReq, NewOperation(70000, 3), config)
+
+	p := ber.DecodePacket(conn.GetWrittenData())
+	if p.Children[0].Value != int64(70000) {
+		t.Errorf("response message id = %v, want 70000", p.Children[0].Value)
+	}
+}
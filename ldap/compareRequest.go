@@ -0,0 +1,72 @@
+package ldap
+
+import (
+	"slices"
+	"smad/models"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Attributes which hold distinguished names, their values are compared as names
+var dnAttributes = []string{"memberof", "member"}
+
+func noSuchAttributeMessage(attribute string) string {
+	return "00002080: AtrErr: DSID-03080155, #1:\n\t0: 00002080: DSID-03080155, problem 1001 (NO_ATTRIBUTE_OR_VAL), data 0, Att 0 (" + attribute + ")\n"
+}
+
+// Does any of the values match the asserted value? Values are case-insensitive like most of
+// the attributes in AD.
+func assertionMatches(attribute string, values []string, assertion string) bool {
+	if slices.Contains(dnAttributes, strings.ToLower(attribute)) {
+		return slices.ContainsFunc(values, func(value string) bool { return normalizeDN(value) == normalizeDN(assertion) })
+	}
+	return slices.ContainsFunc(values, func(value string) bool { return strings.EqualFold(value, assertion) })
+}
+
+func compareObject(config *models.AppConfig, dn, attribute, assertion string) ldapResult {
+	config.RLock()
+	defer config.RUnlock()
+
+	object, ok := findEntry(config, dn)
+	if !ok {
+		return noSuchObjectResult(config, dn)
+	}
+
+	values := attributeValues(object, attribute)
+	if len(values) == 0 {
+		return ldapResult{statusCode: ResultNoSuchAttribute, errorMessage: noSuchAttributeMessage(attribute)}
+	}
+
+	if assertionMatches(attribute, values, assertion) {
+		return ldapResult{statusCode: ResultCompareTrue}
+	}
+	return ldapResult{statusCode: ResultCompareFalse}
+}
+
+// Compares value of object's attribute (RFC 4511, section 4.10). Clients use this for example
+// to check group membership with memberOf attribute.
+func HandleCompareRequest(sess *Session, p *ber.Packet, op *Operation, config *models.AppConfig) {
+	if len(p.Children) != 2 || len(p.Children[1].Children) != 2 {
+		sendResult(sess, op.ID, 0x0f, ldapResult{statusCode: ResultProtocolError, errorMessage: DecodingErrorMessage})
+		return
+	}
+
+	if !sess.BindSuccessful {
+		sendResult(sess, op.ID, 0x0f, ldapResult{statusCode: ResultOperationsError, errorMessage: bindRequiredMessage})
+		return
+	}
+
+	dn := p.Children[0].Data.String()
+	attribute := p.Children[1].Children[0].Data.String()
+	assertion := p.Children[1].Children[1].Data.String()
+
+	result := compareObject(config, dn, attribute, assertion)
+
+	if err := op.finish(); err != nil {
+		sendStoppedResult(sess, op.ID, 0x0f, err)
+		return
+	}
+
+	sendResult(sess, op.ID, 0x0f, result)
+}
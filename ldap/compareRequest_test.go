@@ -0,0 +1,79 @@
+package ldap
+
+import (
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to create compare request packet
+func createCompareRequestPacket(dn, attribute, value string) *ber.Packet {
+	compareReq := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 0x0e, nil, "")
+	compareReq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+
+	ava := ber.NewSequence("")
+	ava.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute, ""))
+	ava.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+	compareReq.AppendChild(ava)
+	return compareReq
+}
+
+func createCompareTestConfig() *models.AppConfig {
+	return createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("user1", "user1@example.com", "pass", []string{"group1"}, map[string]string{"givenName": "One"}),
+	}, []models.Group{createTestGroup("group1"), createTestGroup("group2")})
+}
+
+func TestCompareRequest(t *testing.T) {
+	config := createCompareTestConfig()
+
+	tests := []struct {
+		name      string
+		dn        string
+		attribute string
+		value     string
+		expected  int64
+	}{
+		{"member of group", "CN=user1,CN=Users,DC=example,DC=com", "memberOf", "cn=group1, cn=users, dc=example, dc=com", ResultCompareTrue},
+		{"not member of group", "CN=user1,CN=Users,DC=example,DC=com", "memberOf", "CN=group2,CN=Users,DC=example,DC=com", ResultCompareFalse},
+		{"case-insensitive value", "cn=USER1,cn=users,dc=example,dc=com", "GIVENNAME", "one", ResultCompareTrue},
+		{"object class", "CN=group1,CN=Users,DC=example,DC=com", "objectClass", "group", ResultCompareTrue},
+		{"group member", "CN=group1,CN=Users,DC=example,DC=com", "member", "CN=user1,CN=Users,DC=example,DC=com", ResultCompareTrue},
+		{"missing attribute", "CN=group2,CN=Users,DC=example,DC=com", "memberOf", "CN=group1,CN=Users,DC=example,DC=com", ResultNoSuchAttribute},
+		{"domain", "DC=example,DC=com", "objectClass", "domainDNS", ResultCompareTrue},
+		{"users container", "CN=Users,DC=example,DC=com", "cn", "Users", ResultCompareTrue},
+		{"schema entry", "CN=User,CN=Schema,CN=Configuration,DC=example,DC=com", "objectClass", "classSchema", ResultCompareTrue},
+		{"missing object", "CN=nobody,CN=Users,DC=example,DC=com", "cn", "nobody", ResultNoSuchObject},
+	}
+
+	for _, tt := range tests {
+		conn := mocks.NewMockConn()
+		HandleCompareRequest(createTestSession(conn, true), createCompareRequestPacket(tt.dn, tt.attribute, tt.value), NewOperation(1, 14), config)
+
+		if code := lastResultCode(t, conn); code != tt.expected {
+			t.Errorf("%s: compare returned %d, want %d", tt.name, code, tt.expected)
+		}
+	}
+}
+
+func TestCompareMissingObjectMatchedDN(t *testing.T) {
+	conn := mocks.NewMockConn()
+	HandleCompareRequest(createTestSession(conn, true), createCompareRequestPacket("CN=nobody,CN=Users,DC=example,DC=com", "cn", "nobody"), NewOperation(1, 14), createCompareTestConfig())
+
+	messages := decodeMessages(t, conn)
+	if matchedDN := messages[0].Children[1].Children[1].Value; matchedDN != "CN=Users,DC=example,DC=com" {
+		t.Errorf("missing object should have closest parent as matched DN, got %v", matchedDN)
+	}
+}
+
+func TestCompareRequiresBind(t *testing.T) {
+	conn := mocks.NewMockConn()
+	HandleCompareRequest(createTestSession(conn, false), createCompareRequestPacket("CN=user1,CN=Users,DC=example,DC=com", "cn", "user1"), NewOperation(1, 14), createCompareTestConfig())
+
+	if code := lastResultCode(t, conn); code != ResultOperationsError {
+		t.Errorf("compare without bind returned %d, want operationsError", code)
+	}
+}
@@ -0,0 +1,151 @@
+package ldap
+
+import (
+	"errors"
+	"slices"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Control is a request or response control attached to LDAP message (RFC 4511, section 4.1.11)
+type Control struct {
+	Type        string
+	Criticality bool
+	Value       []byte // nil, if control has no value
+}
+
+// Supported request controls for each operation (by request tag). Critical controls which are
+// not listed here are rejected, non-critical ones are ignored.
+var supportedControls = map[ber.Tag][]string{
+	0x03: {pagedResultsOID, sortRequestOID, vlvRequestOID, notificationOID, dirSyncOID, showDeletedOID, showRecycledOID},
+	0x06: {permissiveModifyOID},
+}
+
+const (
+	controlErrorMessage         = "00002040: LdapErr: DSID-0C090B5C, comment: Error processing control, data 0, v4563"
+	unavailableExtensionMessage = "000020EF: SvcErr: DSID-03140594, problem 5010 (UNAVAIL_EXTENSION), data 0"
+)
+
+// Response type for each request, used when operation fails before reaching its handler
+var responseTags = map[ber.Tag]ber.Tag{
+	0x00: 0x01, // bind
+	0x03: 0x05, // search
+	0x06: 0x07, // modify
+	0x08: 0x09, // add
+	0x0a: 0x0b, // delete
+	0x0c: 0x0d, // modify DN
+	0x0e: 0x0f, // compare
+	0x17: 0x18, // extended
+}
+
+// Parses optional controls of LDAPMessage, which are the third element of the message
+func parseControls(p *ber.Packet) ([]Control, error) {
+	if len(p.Children) < 3 {
+		return nil, nil
+	}
+
+	controlsPacket := p.Children[2]
+	if controlsPacket.ClassType != ber.ClassContext || controlsPacket.Tag != 0 {
+		return nil, errors.New("invalid controls element")
+	}
+
+	var controls []Control
+	for _, controlPacket := range controlsPacket.Children {
+		if len(controlPacket.Children) == 0 || controlPacket.Children[0].Tag != ber.TagOctetString {
+			return nil, errors.New("invalid control")
+		}
+
+		control := Control{Type: controlPacket.Children[0].Data.String()}
+
+		// Both criticality and value are optional
+		for _, child := range controlPacket.Children[1:] {
+			switch child.Tag {
+			case ber.TagBoolean:
+				control.Criticality, _ = child.Value.(bool)
+			case ber.TagOctetString:
+				control.Value = child.Data.Bytes()
+				if control.Value == nil {
+					control.Value = []byte{}
+				}
+			default:
+				return nil, errors.New("invalid control")
+			}
+		}
+
+		controls = append(controls, control)
+	}
+
+	return controls, nil
+}
+
+// Returns control of given type, or nil if client didn't send it
+func findControl(controls []Control, controlType string) *Control {
+	idx := slices.IndexFunc(controls, func(c Control) bool { return c.Type == controlType })
+	if idx < 0 {
+		return nil
+	}
+	return &controls[idx]
+}
+
+// Returns the first critical control which server doesn't support for given operation
+func unsupportedCriticalControl(tag ber.Tag, controls []Control) *Control {
+	for idx, control := range controls {
+		if control.Criticality && !slices.Contains(supportedControls[tag], control.Type) {
+			return &controls[idx]
+		}
+	}
+	return nil
+}
+
+// Appends response controls to LDAPMessage
+func addControlsPkg(rsp *ber.Packet, controls []Control) {
+	if len(controls) == 0 {
+		return
+	}
+
+	controlsPacket := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "")
+	for _, control := range controls {
+		controlPacket := ber.NewSequence("")
+		controlPacket.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, control.Type, ""))
+		if control.Criticality {
+			controlPacket.AppendChild(ber.NewLDAPBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, ""))
+		}
+		if control.Value != nil {
+			controlPacket.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(control.Value), ""))
+		}
+		controlsPacket.AppendChild(controlPacket)
+	}
+
+	rsp.AppendChild(controlsPacket)
+}
+
+// Sends error result for request, which failed before it was handed to the operation handler.
+// Requests without response (unbind, abandon) are silently dropped.
+func sendErrorResponse(sess *Session, msgNum int64, tag ber.Tag, statusCode int, errorMessage string) {
+	responseTag, ok := responseTags[tag]
+	if !ok {
+		return
+	}
+
+	rsp := createResponsePacket(msgNum)
+	rsp.AppendChild(createResultPkg(responseTag, statusCode, "", errorMessage))
+	sess.Write(rsp.Bytes())
+}
+
+// Reads controls of the request to the operation, before the request is processed. Returns
+// false if the request was rejected, in which case error response has already been sent.
+func ProcessControls(sess *Session, op *Operation, p *ber.Packet) bool {
+	controls, err := parseControls(p)
+	if err != nil {
+		sendErrorResponse(sess, op.ID, op.Tag, ResultProtocolError, DecodingErrorMessage)
+		return false
+	}
+	op.Controls = controls
+
+	if control := unsupportedCriticalControl(op.Tag, op.Controls); control != nil {
+		sendErrorResponse(sess, op.ID, op.Tag, ResultUnavailableCriticalExtension, unavailableExtensionMessage)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,136 @@
+package ldap
+
+import (
+	"testing"
+
+	"smad/internal/mocks"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to create control element of LDAPMessage
+func createControlPacket(controlType string, criticality bool, value *ber.Packet) *ber.Packet {
+	control := ber.NewSequence("")
+	control.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, controlType, ""))
+	if criticality {
+		control.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, ""))
+	}
+	if value != nil {
+		control.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(value.Bytes()), ""))
+	}
+	return control
+}
+
+// Helper function to create LDAPMessage with given operation and controls. Message is encoded
+// and decoded again, so that it looks exactly like one read from the connection.
+func createMessageWithControls(msgNum int64, op *ber.Packet, controls ...*ber.Packet) *ber.Packet {
+	msg := ber.NewSequence("")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgNum, ""))
+	msg.AppendChild(op)
+
+	controlsPacket := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "")
+	for _, control := range controls {
+		controlsPacket.AppendChild(control)
+	}
+	msg.AppendChild(controlsPacket)
+
+	return ber.DecodePacket(msg.Bytes())
+}
+
+func TestParseControls(t *testing.T) {
+	value := ber.NewSequence("")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 10, ""))
+
+	msg := createMessageWithControls(1, createSearchRequestPacket("DC=example,DC=com", "objectClass"),
+		createControlPacket("1.2.3.4", true, value),
+		createControlPacket("1.2.3.5", false, nil),
+	)
+
+	controls, err := parseControls(msg)
+	if err != nil {
+		t.Fatalf("parseControls failed: %v", err)
+	}
+	if len(controls) != 2 {
+		t.Fatalf("parseControls returned %d controls, want 2", len(controls))
+	}
+	if controls[0].Type != "1.2.3.4" || !controls[0].Criticality || string(controls[0].Value) != string(value.Bytes()) {
+		t.Errorf("unexpected first control %+v", controls[0])
+	}
+	if controls[1].Type != "1.2.3.5" || controls[1].Criticality || controls[1].Value != nil {
+		t.Errorf("unexpected second control %+v", controls[1])
+	}
+}
+
+func TestParseControlsWithoutControls(t *testing.T) {
+	msg := ber.NewSequence("")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 1, ""))
+	msg.AppendChild(createSearchRequestPacket("DC=example,DC=com", "objectClass"))
+
+	controls, err := parseControls(msg)
+	if err != nil || controls != nil {
+		t.Errorf("message without controls should have no controls, got %v, %v", controls, err)
+	}
+}
+
+func TestParseControlsMalformed(t *testing.T) {
+	// Control type must be an octet string
+	control := ber.NewSequence("")
+	control.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 1, ""))
+
+	msg := createMessageWithControls(1, createSearchRequestPacket("DC=example,DC=com", "objectClass"), control)
+
+	if _, err := parseControls(msg); err == nil {
+		t.Error("parseControls should fail on malformed control")
+	}
+}
+
+func TestProcessControlsUnsupportedCritical(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+
+	msg := createMessageWithControls(4, createSearchRequestPacket("DC=example,DC=com", "objectClass"), createControlPacket("1.2.3.4", true, nil))
+	op := NewOperation(4, 3)
+
+	if ProcessControls(sess, op, msg) {
+		t.Fatal("ProcessControls should reject unsupported critical control")
+	}
+
+	messages := decodeMessages(t, conn)
+	if len(messages) != 1 {
+		t.Fatalf("ProcessControls wrote %d messages, want 1", len(messages))
+	}
+	if messages[0].Children[1].Tag != 0x05 || messages[0].Children[1].Children[0].Value != int64(ResultUnavailableCriticalExtension) {
+		t.Error("unsupported critical control should be answered with unavailableCriticalExtension search result")
+	}
+}
+
+func TestProcessControlsUnsupportedNonCritical(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+
+	msg := createMessageWithControls(4, createSearchRequestPacket("DC=example,DC=com", "objectClass"), createControlPacket("1.2.3.4", false, nil))
+	op := NewOperation(4, 3)
+
+	if !ProcessControls(sess, op, msg) {
+		t.Fatal("ProcessControls should ignore unsupported non-critical control")
+	}
+	if len(op.Controls) != 1 || len(conn.GetWrittenData()) != 0 {
+		t.Error("non-critical control should be stored to operation without response")
+	}
+}
+
+func TestAddControlsPkg(t *testing.T) {
+	rsp := createResponsePacket(1)
+	addEndOfSearchPkg(rsp, ResultSuccess, "")
+	addControlsPkg(rsp, []Control{{Type: "1.2.3.4", Value: []byte{}}})
+
+	decoded := ber.DecodePacket(rsp.Bytes())
+	if len(decoded.Children) != 3 {
+		t.Fatalf("response should have 3 elements, got %d", len(decoded.Children))
+	}
+
+	controls, err := parseControls(decoded)
+	if err != nil || len(controls) != 1 || controls[0].Type != "1.2.3.4" || controls[0].Value == nil {
+		t.Errorf("response control didn't survive encoding: %v, %v", controls, err)
+	}
+}
@@ -1,11 +1,89 @@
 package ldap
 
 import (
-	"net"
+	"slices"
 	"smad/models"
 
 	ber "github.com/go-asn1-ber/asn1-ber"
 )
 
-func HandleDeleteRequest(conn net.Conn, p *ber.Packet, msgNum uint8, bindSuccessful bool, config models.AppConfig) {
+const notAllowedOnNonLeafMessage = "00002015: SvcErr: DSID-031A0FC3, problem 6003 (CANT_ON_NON_LEAF), data 0"
+
+// Does any object or container have the given parent? Directory must be locked for reading.
+func hasChildren(config *models.AppConfig, dn string) bool {
+	isChild := func(childDN string) bool {
+		_, _, parent := splitDN(childDN)
+		return normalizeDN(parent) == normalizeDN(dn)
+	}
+
+	return slices.ContainsFunc(joinGroupsAndUsers(config), func(object models.LdapElement) bool { return isChild(object.DN) }) ||
+		slices.ContainsFunc(containerDNs(config.Configuration.Domain), isChild)
+}
+
+// Removes the object and keeps its tombstone. Members of deleted group lose the membership,
+// and groups of deleted user lose the member. Directory must be locked for writing.
+func deleteObject(config *models.AppConfig, dn string) ldapResult {
+	userIdx, groupIdx, ouIdx := findUserIndex(config, "dn:"+dn), findGroupIndex(config, dn), findOUIndex(config, dn)
+	if userIdx < 0 && groupIdx < 0 && ouIdx < 0 {
+		// Domain, built-in containers, configuration and schema can't be deleted
+		if entryExists(config, dn) {
+			return ldapResult{statusCode: ResultUnwillingToPerform, errorMessage: willNotPerformMessage}
+		}
+		return noSuchObjectResult(config, dn)
+	}
+
+	if hasChildren(config, dn) {
+		return ldapResult{statusCode: ResultNotAllowedOnNonLeaf, errorMessage: notAllowedOnNonLeafMessage}
+	}
+
+	if userIdx >= 0 {
+		user := config.Users[userIdx]
+		createTombstone(config, userElement(config, user))
+		config.Users = slices.Delete(config.Users, userIdx, userIdx+1)
+
+		for idx, group := range config.Groups {
+			if slices.Contains(user.Groups, group.Cn) {
+				markChanged(config, &config.Groups[idx].USN, "member")
+				notifyChange(config, groupElement(config, config.Groups[idx]))
+			}
+		}
+		return ldapResult{}
+	}
+
+	if groupIdx >= 0 {
+		group := config.Groups[groupIdx]
+		createTombstone(config, groupElement(config, group))
+
+		for idx := range config.Users {
+			config.Users[idx].Groups = slices.DeleteFunc(config.Users[idx].Groups, func(g string) bool { return g == group.Cn })
+		}
+		config.Groups = slices.Delete(config.Groups, groupIdx, groupIdx+1)
+		return ldapResult{}
+	}
+
+	createTombstone(config, ouElement(config, config.OrganizationalUnits[ouIdx]))
+	config.OrganizationalUnits = slices.Delete(config.OrganizationalUnits, ouIdx, ouIdx+1)
+	return ldapResult{}
+}
+
+// Deletes user, group or empty OU from the directory (RFC 4511, section 4.8)
+func HandleDeleteRequest(sess *Session, p *ber.Packet, op *Operation, config *models.AppConfig) {
+	if !sess.BindSuccessful {
+		sendResult(sess, op.ID, 0x0b, ldapResult{statusCode: ResultInsufficientAccessRights, errorMessage: insufficientAccessMessage})
+		return
+	}
+
+	dn := p.Data.String()
+
+	// Once the directory is changed, it's too late to stop
+	if err := op.finish(); err != nil {
+		sendStoppedResult(sess, op.ID, 0x0b, err)
+		return
+	}
+
+	config.Lock()
+	result := deleteObject(config, dn)
+	config.Unlock()
+
+	sendResult(sess, op.ID, 0x0b, result)
 }
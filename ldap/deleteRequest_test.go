@@ -0,0 +1,136 @@
+package ldap
+
+import (
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to run delete request, returns result code
+func deleteEntry(t *testing.T, config *models.AppConfig, bound bool, dn string) int64 {
+	conn := mocks.NewMockConn()
+	delReq := ber.NewString(ber.ClassApplication, ber.TypePrimitive, 0x0a, dn, "")
+	HandleDeleteRequest(createTestSession(conn, bound), delReq, NewOperation(1, 10), config)
+	return lastResultCode(t, conn)
+}
+
+func createDeleteTestConfig() *models.AppConfig {
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("user1", "user1@example.com", "pass", []string{"group1", "group2"}, nil),
+		createTestUser("user2", "user2@example.com", "pass", []string{"group1"}, nil),
+	}, []models.Group{createTestGroup("group1"), createTestGroup("group2")})
+	config.Users[0].ObjectGUID = "0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f"
+	config.Groups[0].ObjectGUID = "1b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f"
+	return config
+}
+
+func TestDeleteUser(t *testing.T) {
+	config := createDeleteTestConfig()
+
+	if code := deleteEntry(t, config, true, "cn=user1,cn=users,dc=example,dc=com"); code != ResultSuccess {
+		t.Fatalf("delete returned %d", code)
+	}
+	if len(config.Users) != 1 || config.Users[0].Cn != "user2" {
+		t.Fatalf("user should be removed, got %+v", config.Users)
+	}
+	if len(config.Tombstones) != 1 || config.Tombstones[0].Attributes["lastKnownParent"] != "CN=Users,DC=example,DC=com" {
+		t.Errorf("deleted user should be kept as tombstone, got %+v", config.Tombstones)
+	}
+	if config.Groups[1].AttributeUSNs["member"] == 0 {
+		t.Error("group of deleted user should have changed member attribute")
+	}
+
+	if code := deleteEntry(t, config, true, "CN=user1,CN=Users,DC=example,DC=com"); code != ResultNoSuchObject {
+		t.Errorf("deleting missing user returned %d, want noSuchObject", code)
+	}
+}
+
+func TestDeleteGroupRemovesMembership(t *testing.T) {
+	config := createDeleteTestConfig()
+
+	if code := deleteEntry(t, config, true, "CN=group1,CN=Users,DC=example,DC=com"); code != ResultSuccess {
+		t.Fatalf("delete returned %d", code)
+	}
+	if len(config.Groups) != 1 {
+		t.Fatalf("group should be removed, got %+v", config.Groups)
+	}
+	if len(config.Users[0].Groups) != 1 || config.Users[0].Groups[0] != "group2" || len(config.Users[1].Groups) != 0 {
+		t.Errorf("members should lose deleted group, got %v and %v", config.Users[0].Groups, config.Users[1].Groups)
+	}
+
+	// memberOf of remaining users doesn't refer to the deleted group
+	config.RLock()
+	object, _ := findObject(config, "CN=user1,CN=Users,DC=example,DC=com")
+	config.RUnlock()
+	if len(object.MemberOf) != 1 || object.MemberOf[0] != "CN=group2,CN=Users,DC=example,DC=com" {
+		t.Errorf("memberOf should only have remaining group, got %v", object.MemberOf)
+	}
+}
+
+func TestDeleteNonLeaf(t *testing.T) {
+	config := createDeleteTestConfig()
+	config.OrganizationalUnits = []models.OrganizationalUnit{{Ou: "Staff"}}
+	config.Users[1].Container = "OU=Staff"
+
+	if code := deleteEntry(t, config, true, "OU=Staff,DC=example,DC=com"); code != ResultNotAllowedOnNonLeaf {
+		t.Errorf("deleting OU with children returned %d, want notAllowedOnNonLeaf", code)
+	}
+
+	if code := deleteEntry(t, config, true, "CN=user2,OU=Staff,DC=example,DC=com"); code != ResultSuccess {
+		t.Fatalf("deleting user in OU returned %d", code)
+	}
+	if code := deleteEntry(t, config, true, "OU=Staff,DC=example,DC=com"); code != ResultSuccess {
+		t.Errorf("deleting empty OU returned %d", code)
+	}
+}
+
+func TestDeleteBuiltInEntries(t *testing.T) {
+	config := createDeleteTestConfig()
+
+	for _, dn := range []string{
+		"DC=example,DC=com",
+		"CN=Users,DC=example,DC=com",
+		"CN=Deleted Objects,DC=example,DC=com",
+		"CN=Configuration,DC=example,DC=com",
+		"CN=Schema,CN=Configuration,DC=example,DC=com",
+		"CN=Aggregate,CN=Schema,CN=Configuration,DC=example,DC=com",
+		"CN=User,CN=Schema,CN=Configuration,DC=example,DC=com",
+	} {
+		if code := deleteEntry(t, config, true, dn); code != ResultUnwillingToPerform {
+			t.Errorf("deleting %s returned %d, want unwillingToPerform", dn, code)
+		}
+	}
+
+	if code := deleteEntry(t, config, true, "CN=Missing,CN=Schema,CN=Configuration,DC=example,DC=com"); code != ResultNoSuchObject {
+		t.Errorf("deleting missing schema entry returned %d, want noSuchObject", code)
+	}
+}
+
+func TestDeleteEscapedName(t *testing.T) {
+	config := createDeleteTestConfig()
+	config.Users[0].Cn = "Doe, John"
+
+	if code := deleteEntry(t, config, true, `CN=Doe\, John,CN=Users,DC=example,DC=com`); code != ResultSuccess {
+		t.Fatalf("delete returned %d", code)
+	}
+	if len(config.Users) != 1 || len(config.Tombstones) != 1 {
+		t.Fatalf("user with escaped name should be deleted, got %+v", config.Users)
+	}
+	if dn := config.Tombstones[0].DN; dn != `CN=Doe\, John\0ADEL:0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f,CN=Deleted Objects,DC=example,DC=com` {
+		t.Errorf("tombstone has wrong DN %s", dn)
+	}
+}
+
+func TestDeleteRequiresBind(t *testing.T) {
+	config := createDeleteTestConfig()
+
+	if code := deleteEntry(t, config, false, "CN=user1,CN=Users,DC=example,DC=com"); code != ResultInsufficientAccessRights {
+		t.Errorf("delete without bind returned %d, want insufficientAccessRights", code)
+	}
+	if len(config.Users) != 2 {
+		t.Error("user should not be deleted without bind")
+	}
+}
@@ -0,0 +1,218 @@
+package ldap
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"smad/models"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+const dirSyncOID = "1.2.840.113556.1.4.841"
+
+// Cookie is the prefix followed by the highest USN client has seen
+const dirSyncCookiePrefix = "SMAD"
+
+// DirSync flags. Access isn't restricted and secrets are never returned, so object security
+// and public data only don't change anything. Incremental values can't be supported, because
+// changes aren't tracked per value.
+const (
+	dirSyncObjectSecurity      = 0x00000001
+	dirSyncAncestorsFirstOrder = 0x00000800
+	dirSyncPublicDataOnly      = 0x00002000
+	dirSyncIncrementalValues   = 0x80000000
+
+	supportedDirSyncFlags = dirSyncObjectSecurity | dirSyncAncestorsFirstOrder | dirSyncPublicDataOnly
+)
+
+type dirSyncRequest struct {
+	flags     int64
+	maxBytes  int
+	watermark int64 // 0 for the first synchronization
+}
+
+// Parses value of DirSync control: flags, maximum size of response and cookie of previous
+// synchronization
+func parseDirSyncControl(value []byte) (dirSyncRequest, error) {
+	var request dirSyncRequest
+
+	valuePacket, err := ber.DecodePacketErr(value)
+	if err != nil {
+		return request, err
+	}
+
+	if len(valuePacket.Children) != 3 {
+		return request, errors.New("invalid DirSync control")
+	}
+
+	flags, ok1 := valuePacket.Children[0].Value.(int64)
+	maxBytes, ok2 := valuePacket.Children[1].Value.(int64)
+	if !ok1 || !ok2 || valuePacket.Children[2].Tag != ber.TagOctetString {
+		return request, errors.New("invalid DirSync control")
+	}
+	request.flags = flags
+	request.maxBytes = int(max(maxBytes, 0))
+
+	cookie := valuePacket.Children[2].Data.Bytes()
+	if len(cookie) > 0 {
+		watermark, ok := strings.CutPrefix(string(cookie), dirSyncCookiePrefix)
+		if !ok || len(watermark) != 8 {
+			return request, errors.New("invalid DirSync cookie")
+		}
+		request.watermark = int64(binary.BigEndian.Uint64([]byte(watermark)))
+	}
+
+	return request, nil
+}
+
+func createDirSyncResponseControl(moreResults bool, watermark int64) Control {
+	cookie := binary.BigEndian.AppendUint64([]byte(dirSyncCookiePrefix), uint64(watermark))
+
+	moreResultsValue := 0
+	if moreResults {
+		moreResultsValue = 1
+	}
+
+	value := ber.NewSequence("")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, moreResultsValue, ""))
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 0, ""))
+	value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(cookie), ""))
+	return Control{Type: dirSyncOID, Value: value.Bytes()}
+}
+
+// Leaves out the attributes which haven't changed since the watermark. Objects created after
+// the watermark are returned whole.
+func changedAttributes(object models.LdapElement, watermark int64) models.LdapElement {
+	if object.USNCreated > watermark {
+		return object
+	}
+
+	changed := func(attribute string) bool { return object.AttributeUSNs[strings.ToLower(attribute)] > watermark }
+
+	attributes := make(map[string]string)
+	for key, value := range object.Attributes {
+		if changed(key) {
+			attributes[key] = value
+		}
+	}
+	object.Attributes = attributes
+
+	if !changed("memberOf") {
+		object.MemberOf = nil
+	}
+	if !changed("member") {
+		object.Member = nil
+	}
+	if !changed("userAccountControl") {
+		object.UserAccountControl = -1
+	}
+
+	return object
+}
+
+// Objects changed after the watermark, in the order of changes
+func dirSyncChanges(objects []models.LdapElement, watermark int64) []models.LdapElement {
+	var changes []models.LdapElement
+	for _, object := range objects {
+		if object.USNChanged > watermark {
+			changes = append(changes, changedAttributes(object, watermark))
+		}
+	}
+
+	slices.SortStableFunc(changes, func(a, b models.LdapElement) int { return cmp.Compare(a.USNChanged, b.USNChanged) })
+	return changes
+}
+
+// Deleted object stays in scope of the synchronization, which saw it before it was deleted
+func tombstonesInScope(tombstones []models.LdapElement, baseDN string, scope int64) []models.LdapElement {
+	var found []models.LdapElement
+	for _, tombstone := range tombstones {
+		parent := tombstone.Attributes["lastKnownParent"]
+		if len(objectsInScope([]models.LdapElement{tombstone}, baseDN, scope)) > 0 ||
+			(scope == scopeSingleLevel && parent != "" && normalizeDN(parent) == normalizeDN(baseDN)) ||
+			(scope == scopeWholeSubtree && parent != "" && isSameOrUnder(parent, baseDN)) {
+			found = append(found, tombstone)
+		}
+	}
+	return found
+}
+
+// DirSync search returns objects changed since previous synchronization, including deleted
+// ones like AD does. Response cookie tells where the next synchronization continues from.
+func dirSyncSearch(sess *Session, p *ber.Packet, op *Operation, request dirSyncRequest, config *models.AppConfig) {
+	eosp := createResponsePacket(op.ID)
+
+	baseDN := p.Children[0].Data.String()
+	scope, _ := ber.ParseInt64(p.Children[1].Data.Bytes())
+
+	if request.flags&^supportedDirSyncFlags != 0 {
+		addEndOfSearchPkg(eosp, ResultUnwillingToPerform, controlErrorMessage)
+		sess.Write(eosp.Bytes())
+		return
+	}
+
+	// Base and scope limit the synchronized objects like in normal search
+	config.RLock()
+	var tombstones []models.LdapElement
+	allObjects, ok := namingContextElements(config, baseDN)
+	if !ok {
+		allObjects = append(containerElements(config), joinGroupsAndUsers(config)...)
+		tombstones = tombstonesInScope(config.Tombstones, baseDN, scope)
+	}
+	watermark := config.HighestUSN
+
+	if !slices.ContainsFunc(allObjects, func(object models.LdapElement) bool { return normalizeDN(object.DN) == normalizeDN(baseDN) }) {
+		result := noSuchObjectResult(config, baseDN)
+		config.RUnlock()
+
+		eosp.AppendChild(createResultPkg(0x05, result.statusCode, result.matchedDN, result.errorMessage))
+		sess.Write(eosp.Bytes())
+		return
+	}
+	config.RUnlock()
+
+	allObjects = append(objectsInScope(allObjects, baseDN, scope), tombstones...)
+	changes := dirSyncChanges(filterObjects(allObjects, p.Children[6]), request.watermark)
+
+	// Parents are returned before their children, if client asks for it
+	if request.flags&dirSyncAncestorsFirstOrder != 0 {
+		slices.SortStableFunc(changes, func(a, b models.LdapElement) int { return cmp.Compare(len(parentDNs(a.DN)), len(parentDNs(b.DN))) })
+	}
+
+	options := parseEntryOptions(p, config.Configuration.MaxValRange)
+
+	// Client can limit the size of the response, rest of the changes are returned next time
+	moreResults := false
+	size := 0
+	for idx, object := range changes {
+		if op.stopped() {
+			break
+		}
+
+		msg := createSearchResEntryMessage(op.ID, object, options).Bytes()
+		size += len(msg)
+		if idx > 0 && request.maxBytes > 0 && size > request.maxBytes {
+			// Next synchronization continues from the oldest change which wasn't sent
+			moreResults = true
+			watermark = slices.MinFunc(changes[idx:], func(a, b models.LdapElement) int { return cmp.Compare(a.USNChanged, b.USNChanged) }).USNChanged - 1
+			break
+		}
+
+		sess.Write(msg)
+	}
+
+	if err := op.finish(); err != nil {
+		if err == errCanceled {
+			addEndOfSearchPkg(eosp, ResultCanceled, "")
+			sess.Write(eosp.Bytes())
+		}
+		return
+	}
+
+	addEndOfSearchPkg(eosp, ResultSuccess, "")
+	addControlsPkg(eosp, []Control{createDirSyncResponseControl(moreResults, watermark)})
+	sess.Write(eosp.Bytes())
+}
@@ -0,0 +1,205 @@
+package ldap
+
+import (
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to create DirSync request control
+func createDirSyncRequest(flags, maxBytes int64, cookie string) Control {
+	value := ber.NewSequence("")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, flags, ""))
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, maxBytes, ""))
+	value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, cookie, ""))
+	return Control{Type: dirSyncOID, Criticality: true, Value: value.Bytes()}
+}
+
+// Helper function to run DirSync search. Returns returned entries, result code, more results
+// flag and cookie of the response.
+func dirSyncSearchRequest(t *testing.T, config *models.AppConfig, maxBytes int64, cookie string) ([]*ber.Packet, int64, int64, string) {
+	return dirSyncSearchPacket(t, config, createSearchRequestPacket("DC=example,DC=com", ""), createDirSyncRequest(0, maxBytes, cookie))
+}
+
+func dirSyncSearchPacket(t *testing.T, config *models.AppConfig, p *ber.Packet, dirSync Control) ([]*ber.Packet, int64, int64, string) {
+	conn := mocks.NewMockConn()
+	op := NewOperation(1, 3)
+	op.Controls = []Control{dirSync}
+	HandleSearchRequest(createTestSession(conn, true), p, op, config)
+
+	messages := decodeMessages(t, conn)
+	done := messages[len(messages)-1]
+
+	controls, _ := parseControls(done)
+	control := findControl(controls, dirSyncOID)
+	if control == nil {
+		return messages[:len(messages)-1], done.Children[1].Children[0].Value.(int64), 0, ""
+	}
+
+	value := ber.DecodePacket(control.Value)
+	return messages[:len(messages)-1], done.Children[1].Children[0].Value.(int64), value.Children[0].Value.(int64), value.Children[2].Data.String()
+}
+
+// Helper function to find attribute values of search result entry
+func entryAttribute(entry *ber.Packet, attribute string) []string {
+	for _, attr := range entry.Children[1].Children[1].Children {
+		if attr.Children[0].Value == attribute {
+			var values []string
+			for _, value := range attr.Children[1].Children {
+				values = append(values, value.Value.(string))
+			}
+			return values
+		}
+	}
+	return nil
+}
+
+func createDirSyncTestConfig() *models.AppConfig {
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("user1", "user1@example.com", "pass", nil, map[string]string{"givenName": "One", "sn": "User"}),
+		createTestUser("user2", "user2@example.com", "pass", nil, map[string]string{"givenName": "Two", "sn": "User"}),
+	}, []models.Group{createTestGroup("group1")})
+
+	config.Groups[0].USN = models.USN{USNCreated: 1, USNChanged: 1}
+	config.Users[0].USN = models.USN{USNCreated: 2, USNChanged: 2}
+	config.Users[1].USN = models.USN{USNCreated: 3, USNChanged: 3}
+	config.HighestUSN = 3
+	return config
+}
+
+func TestDirSyncIncremental(t *testing.T) {
+	config := createDirSyncTestConfig()
+
+	// First synchronization returns everything
+	entries, resultCode, moreResults, cookie := dirSyncSearchRequest(t, config, 0, "")
+	if len(entries) != 3 || resultCode != ResultSuccess || moreResults != 0 || cookie == "" {
+		t.Fatalf("first DirSync = %d entries, result %d, more results %d, cookie %q", len(entries), resultCode, moreResults, cookie)
+	}
+
+	// Nothing has changed
+	entries, _, _, cookie = dirSyncSearchRequest(t, config, 0, cookie)
+	if len(entries) != 0 {
+		t.Fatalf("DirSync without changes returned %d entries", len(entries))
+	}
+
+	config.Users[1].Attributes["sn"] = "Changed"
+	markChanged(config, &config.Users[1].USN, "sn")
+
+	entries, _, _, _ = dirSyncSearchRequest(t, config, 0, cookie)
+	if len(entries) != 1 || entries[0].Children[1].Children[0].Value != "CN=user2,CN=Users,DC=example,DC=com" {
+		t.Fatalf("DirSync should return only changed user, got %d entries", len(entries))
+	}
+	if sn := entryAttribute(entries[0], "sn"); len(sn) != 1 || sn[0] != "Changed" {
+		t.Errorf("DirSync should return changed attribute, got %v", sn)
+	}
+	if givenName := entryAttribute(entries[0], "givenName"); givenName != nil {
+		t.Errorf("DirSync should not return unchanged attribute, got %v", givenName)
+	}
+}
+
+func TestDirSyncDeletedObjects(t *testing.T) {
+	config := createDirSyncTestConfig()
+	_, _, _, cookie := dirSyncSearchRequest(t, config, 0, "")
+
+	config.HighestUSN++
+	config.Tombstones = append(config.Tombstones, models.LdapElement{
+		DN:                 "CN=user3\\0ADEL:0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f,CN=Deleted Objects,DC=example,DC=com",
+		Cn:                 "user3\nDEL:0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f",
+		ObjectClass:        []string{"top", "person", "organizationalPerson", "user"},
+		Attributes:         map[string]string{"isDeleted": "TRUE"},
+		UserAccountControl: -1,
+		USN:                models.USN{USNCreated: 1, USNChanged: config.HighestUSN, AttributeUSNs: map[string]int64{"isdeleted": config.HighestUSN}},
+	})
+
+	entries, _, _, _ := dirSyncSearchRequest(t, config, 0, cookie)
+	if len(entries) != 1 {
+		t.Fatalf("DirSync should return deleted object, got %d entries", len(entries))
+	}
+	if isDeleted := entryAttribute(entries[0], "isDeleted"); len(isDeleted) != 1 || isDeleted[0] != "TRUE" {
+		t.Errorf("deleted object should have isDeleted attribute, got %v", isDeleted)
+	}
+}
+
+func TestDirSyncMaxBytes(t *testing.T) {
+	config := createDirSyncTestConfig()
+
+	// Tiny limit still returns one object at a time
+	entries, _, moreResults, cookie := dirSyncSearchRequest(t, config, 10, "")
+	if len(entries) != 1 || moreResults != 1 {
+		t.Fatalf("limited DirSync = %d entries, more results %d, want 1 entry and more results", len(entries), moreResults)
+	}
+
+	total := 1
+	for i := 0; i < 5 && moreResults == 1; i++ {
+		entries, _, moreResults, cookie = dirSyncSearchRequest(t, config, 10, cookie)
+		total += len(entries)
+	}
+	if total != 3 || moreResults != 0 {
+		t.Errorf("limited DirSync returned %d entries in total, want 3", total)
+	}
+}
+
+func TestDirSyncBaseAndScope(t *testing.T) {
+	config := createDirSyncTestConfig()
+	config.OrganizationalUnits = []models.OrganizationalUnit{{Ou: "Staff", USN: models.USN{USNCreated: 4, USNChanged: 4}}}
+	config.Users[1].Container = "OU=Staff"
+	config.Tombstones = append(config.Tombstones, models.LdapElement{
+		DN:                 "CN=user3\\0ADEL:0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f,CN=Deleted Objects,DC=example,DC=com",
+		Cn:                 "user3\nDEL:0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f",
+		ObjectClass:        []string{"top", "person", "organizationalPerson", "user"},
+		Attributes:         map[string]string{"isDeleted": "TRUE", "lastKnownParent": "OU=Staff,DC=example,DC=com"},
+		UserAccountControl: -1,
+		USN:                models.USN{USNCreated: 5, USNChanged: 5},
+	})
+	config.HighestUSN = 5
+
+	// Objects outside the base aren't synchronized, deleted ones are by their last known parent
+	entries, resultCode, _, _ := dirSyncSearchPacket(t, config, createSearchRequestPacket("OU=Staff,DC=example,DC=com", ""), createDirSyncRequest(0, 0, ""))
+	if resultCode != ResultSuccess || len(entries) != 3 {
+		t.Fatalf("DirSync of OU = %d entries, result %d, want 3 entries", len(entries), resultCode)
+	}
+	for idx, dn := range []string{"CN=user2,OU=Staff,DC=example,DC=com", "OU=Staff,DC=example,DC=com", "CN=user3\\0ADEL:0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f,CN=Deleted Objects,DC=example,DC=com"} {
+		if entries[idx].Children[1].Children[0].Value != dn {
+			t.Errorf("DirSync entry %d = %v, want %s", idx, entries[idx].Children[1].Children[0].Value, dn)
+		}
+	}
+
+	// One level doesn't include the base itself
+	levelSearch := createSearchRequestPacket("OU=Staff,DC=example,DC=com", "")
+	levelSearch.Children[1] = ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, scopeSingleLevel, "")
+	if entries, _, _, _ := dirSyncSearchPacket(t, config, levelSearch, createDirSyncRequest(0, 0, "")); len(entries) != 2 {
+		t.Errorf("one level DirSync of OU returned %d entries, want 2", len(entries))
+	}
+
+	// Parents can be asked before their children
+	entries, _, _, _ = dirSyncSearchPacket(t, config, createSearchRequestPacket("OU=Staff,DC=example,DC=com", ""), createDirSyncRequest(dirSyncAncestorsFirstOrder, 0, ""))
+	if len(entries) != 3 || entries[0].Children[1].Children[0].Value != "OU=Staff,DC=example,DC=com" {
+		t.Error("DirSync with ancestors first order should return OU before its user")
+	}
+
+	if _, resultCode, _, _ := dirSyncSearchPacket(t, config, createSearchRequestPacket("OU=Missing,DC=example,DC=com", ""), createDirSyncRequest(0, 0, "")); resultCode != ResultNoSuchObject {
+		t.Errorf("DirSync of missing base = result %d, want noSuchObject", resultCode)
+	}
+}
+
+func TestDirSyncUnsupportedFlags(t *testing.T) {
+	config := createDirSyncTestConfig()
+
+	if _, resultCode, _, _ := dirSyncSearchPacket(t, config, createSearchRequestPacket("DC=example,DC=com", ""), createDirSyncRequest(dirSyncObjectSecurity|dirSyncPublicDataOnly, 0, "")); resultCode != ResultSuccess {
+		t.Errorf("DirSync with supported flags = result %d, want success", resultCode)
+	}
+	if _, resultCode, _, _ := dirSyncSearchPacket(t, config, createSearchRequestPacket("DC=example,DC=com", ""), createDirSyncRequest(dirSyncIncrementalValues, 0, "")); resultCode != ResultUnwillingToPerform {
+		t.Errorf("DirSync with incremental values = result %d, want unwillingToPerform", resultCode)
+	}
+}
+
+func TestDirSyncInvalidCookie(t *testing.T) {
+	config := createDirSyncTestConfig()
+
+	if _, resultCode, _, _ := dirSyncSearchRequest(t, config, 0, "bogus"); resultCode != ResultProtocolError {
+		t.Errorf("DirSync with invalid cookie = result %d, want protocolError", resultCode)
+	}
+}
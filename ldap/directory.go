@@ -0,0 +1,330 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"smad/models"
+	"strings"
+)
+
+// Normalizes distinguished name for comparison: attribute names and values are case
+// insensitive in AD, whitespace around separators is not significant and same character can
+// be escaped in different ways
+func normalizeDN(dn string) string {
+	parts := splitRDNs(dn)
+	for idx, part := range parts {
+		attr, value, _ := strings.Cut(part, "=")
+		parts[idx] = strings.TrimSpace(attr) + "=" + escapeRDNValue(unescapeRDNValue(value))
+	}
+	return strings.ToLower(strings.Join(parts, ","))
+}
+
+// Splits distinguished name to relative distinguished names, commas escaped with backslash
+// are part of the value (RFC 4514, section 2.4)
+func splitRDNs(dn string) []string {
+	var rdns []string
+	start := 0
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',':
+			rdns = append(rdns, dn[start:i])
+			start = i + 1
+		}
+	}
+	return append(rdns, dn[start:])
+}
+
+// Escapes the special characters of attribute value in distinguished name. Control characters
+// are escaped as hex pairs, like AD does for the newline in the names of deleted objects.
+func escapeRDNValue(value string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case strings.IndexByte(`"+,;<>\`, c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			escaped.WriteByte('\\')
+			escaped.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&escaped, "\\%02X", c)
+		default:
+			escaped.WriteByte(c)
+		}
+	}
+	return escaped.String()
+}
+
+// Reverses escapeRDNValue, also accepting any character escaped as hex pair. Unescaped
+// whitespace around the value is not significant.
+func unescapeRDNValue(value string) string {
+	value = strings.TrimLeft(value, " ")
+
+	var unescaped []byte
+	significant := 0 // escaped trailing spaces are kept
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			unescaped = append(unescaped, value[i])
+			continue
+		}
+
+		if decoded, err := hex.DecodeString(value[i+1 : min(i+3, len(value))]); err == nil && len(decoded) == 1 {
+			unescaped = append(unescaped, decoded[0])
+			i += 2
+		} else {
+			unescaped = append(unescaped, value[i+1])
+			i++
+		}
+		significant = len(unescaped)
+	}
+
+	for len(unescaped) > significant && unescaped[len(unescaped)-1] == ' ' {
+		unescaped = unescaped[:len(unescaped)-1]
+	}
+	return string(unescaped)
+}
+
+// Distinguished name of the domain, like DC=example,DC=com for example.com
+func domainDN(domain string) string {
+	parts := strings.Split(domain, ".")
+	for idx, part := range parts {
+		parts[idx] = "DC=" + part
+	}
+	return strings.Join(parts, ",")
+}
+
+// Container of users and groups which are directly under the domain. Empty container already
+// means CN=Users, so the domain itself is marked separately.
+const domainContainer = "."
+
+// Distinguished name of the container of user or group, container is relative to the domain
+// and empty container means the default CN=Users
+func containerDN(container, domain string) string {
+	switch container {
+	case "":
+		container = "CN=Users"
+	case domainContainer:
+		return domainDN(domain)
+	}
+	return container + "," + domainDN(domain)
+}
+
+func userDN(user models.User, domain string) string {
+	return "CN=" + escapeRDNValue(user.Cn) + "," + containerDN(user.Container, domain)
+}
+
+// Distinguished name of the group by its name. Directory must be locked for reading.
+func groupDN(config *models.AppConfig, cn string) string {
+	container := ""
+	if idx := slices.IndexFunc(config.Groups, func(c models.Group) bool { return c.Cn == cn }); idx >= 0 {
+		container = config.Groups[idx].Container
+	}
+	return "CN=" + escapeRDNValue(cn) + "," + containerDN(container, config.Configuration.Domain)
+}
+
+// OUs can also be directly under the domain
+func ouDN(ou models.OrganizationalUnit, domain string) string {
+	if ou.Container == "" {
+		return "OU=" + escapeRDNValue(ou.Ou) + "," + domainDN(domain)
+	}
+	return "OU=" + escapeRDNValue(ou.Ou) + "," + ou.Container + "," + domainDN(domain)
+}
+
+// Finds user by identity, which can be userPrincipalName, distinguished name or authorization
+// identity in 'dn:<dn>' or 'u:<DOMAIN>\<user>' form. Returns -1 if user is not found.
+func findUserIndex(config *models.AppConfig, identity string) int {
+	domain := config.Configuration.Domain
+
+	var match func(c models.User) bool
+	if dn, ok := strings.CutPrefix(identity, "dn:"); ok {
+		match = func(c models.User) bool {
+			return normalizeDN(userDN(c, domain)) == normalizeDN(dn)
+		}
+	} else if account, ok := strings.CutPrefix(identity, "u:"); ok {
+		_, name, _ := strings.Cut(account, "\\")
+		match = func(c models.User) bool { return strings.EqualFold(samAccountName(c), name) }
+	} else if strings.Contains(identity, "=") {
+		match = func(c models.User) bool {
+			return normalizeDN(userDN(c, domain)) == normalizeDN(identity)
+		}
+	} else {
+		match = func(c models.User) bool { return strings.EqualFold(c.Upn, identity) }
+	}
+
+	return slices.IndexFunc(config.Users, match)
+}
+
+// Finds the user the session is bound as. Returns -1 for anonymous session, or if the user
+// has been deleted. Directory must be locked for reading.
+func boundUserIndex(config *models.AppConfig, sess *Session) int {
+	if !sess.BindSuccessful || sess.BindUser == "" {
+		return -1
+	}
+	return slices.IndexFunc(config.Users, func(c models.User) bool { return c.ObjectGUID == sess.BindUser })
+}
+
+// Finds group by distinguished name. Returns -1 if group is not found.
+func findGroupIndex(config *models.AppConfig, dn string) int {
+	return slices.IndexFunc(config.Groups, func(c models.Group) bool {
+		return normalizeDN("CN="+escapeRDNValue(c.Cn)+","+containerDN(c.Container, config.Configuration.Domain)) == normalizeDN(dn)
+	})
+}
+
+// Finds OU by distinguished name. Returns -1 if OU is not found.
+func findOUIndex(config *models.AppConfig, dn string) int {
+	return slices.IndexFunc(config.OrganizationalUnits, func(c models.OrganizationalUnit) bool {
+		return normalizeDN(ouDN(c, config.Configuration.Domain)) == normalizeDN(dn)
+	})
+}
+
+// Members of 'Domain Admins' group are allowed to manage other users
+func isAdministrator(user models.User) bool {
+	return slices.ContainsFunc(user.Groups, func(group string) bool { return strings.EqualFold(group, "Domain Admins") })
+}
+
+// Records change of the object with next update sequence number. Directory must be locked
+// for writing.
+func markChanged(config *models.AppConfig, usn *models.USN, attributes ...string) {
+	config.HighestUSN++
+	usn.USNChanged = config.HighestUSN
+
+	if usn.AttributeUSNs == nil {
+		usn.AttributeUSNs = make(map[string]int64)
+	}
+	for _, attribute := range attributes {
+		usn.AttributeUSNs[strings.ToLower(attribute)] = config.HighestUSN
+	}
+}
+
+// Finds user, group or OU by distinguished name. Directory must be locked for reading.
+func findObject(config *models.AppConfig, dn string) (models.LdapElement, bool) {
+	for _, object := range joinGroupsAndUsers(config) {
+		if normalizeDN(object.DN) == normalizeDN(dn) {
+			return object, true
+		}
+	}
+	return models.LdapElement{}, false
+}
+
+// Finds any entry which searches return without controls: the domain, built-in containers,
+// configuration and schema entries in addition to users, groups and OUs. Directory must be
+// locked for reading.
+func findEntry(config *models.AppConfig, dn string) (models.LdapElement, bool) {
+	entries := append(containerElements(config), joinGroupsAndUsers(config)...)
+	entries = append(entries, configurationElements(config)...)
+	for _, entry := range append(entries, schemaElements(config)...) {
+		if normalizeDN(entry.DN) == normalizeDN(dn) {
+			return entry, true
+		}
+	}
+	return models.LdapElement{}, false
+}
+
+// Is the name already taken by an entry, including the built-in containers which aren't
+// returned by searches (like CN=Deleted Objects). Directory must be locked for reading.
+func entryExists(config *models.AppConfig, dn string) bool {
+	if _, ok := findEntry(config, dn); ok {
+		return true
+	}
+	return slices.ContainsFunc(containerDNs(config.Configuration.Domain), func(container string) bool {
+		return normalizeDN(container) == normalizeDN(dn)
+	})
+}
+
+// Containers which always exist in the directory
+func containerDNs(domain string) []string {
+	return []string{domainDN(domain), "CN=Users," + domainDN(domain), deletedObjectsDN(domain), configurationDN(domain), schemaDN(domain), subschemaDN(domain)}
+}
+
+// Returns the closest existing parent of a missing object, which AD reports as matched DN.
+// Directory must be locked for reading.
+func bestMatch(config *models.AppConfig, dn string) string {
+	for _, parent := range parentDNs(dn) {
+		if _, ok := findObject(config, parent); ok {
+			return parent
+		}
+		if slices.ContainsFunc(containerDNs(config.Configuration.Domain), func(container string) bool {
+			return normalizeDN(container) == normalizeDN(parent)
+		}) {
+			return parent
+		}
+	}
+	return ""
+}
+
+// Parents of the distinguished name, starting from the closest one
+func parentDNs(dn string) []string {
+	rdns := splitRDNs(dn)
+
+	var parents []string
+	for idx := 1; idx < len(rdns); idx++ {
+		parents = append(parents, strings.Join(rdns[idx:], ","))
+	}
+	return parents
+}
+
+// Missing object is reported with its closest existing parent. Directory must be locked for
+// reading.
+func noSuchObjectResult(config *models.AppConfig, dn string) ldapResult {
+	matchedDN := bestMatch(config, dn)
+	return ldapResult{
+		statusCode:   ResultNoSuchObject,
+		matchedDN:    matchedDN,
+		errorMessage: "0000208D: NameErr: DSID-0310028C, problem 2001 (NO_OBJECT), data 0, best match of:\n\t'" + matchedDN + "'\n",
+	}
+}
+
+// Splits distinguished name to naming attribute, its unescaped value and the parent
+func splitDN(dn string) (string, string, string) {
+	rdns := splitRDNs(dn)
+	attr, value, _ := strings.Cut(rdns[0], "=")
+	return strings.TrimSpace(attr), unescapeRDNValue(value), strings.TrimSpace(strings.Join(rdns[1:], ","))
+}
+
+func invalidDNSyntaxMessage(dn string) string {
+	return "00002081: NameErr: DSID-03050F42, problem 2003 (BAD_ATT_SYNTAX), data 0, best match of:\n\t'" + dn + "'\n"
+}
+
+func namingViolationMessage(dn string) string {
+	return "00002099: NameErr: DSID-0305109C, problem 2005 (NAMING_VIOLATION), data 0, best match of:\n\t'" + dn + "'\n"
+}
+
+// Resolves the parent of an object to container relative to the domain. Users and groups can
+// be directly under the domain, in CN=Users or in an OU, OUs directly under the domain or in
+// another OU. Directory must be locked for reading.
+func resolveContainer(config *models.AppConfig, parent string, isOU bool) (string, ldapResult) {
+	domain := config.Configuration.Domain
+
+	switch normalizeDN(parent) {
+	case normalizeDN(domainDN(domain)):
+		if isOU {
+			return "", ldapResult{}
+		}
+		return domainContainer, ldapResult{}
+	case normalizeDN(containerDN("", domain)):
+		if !isOU {
+			return "", ldapResult{}
+		}
+		return "", ldapResult{statusCode: ResultNamingViolation, errorMessage: namingViolationMessage(parent)}
+	}
+
+	for _, ou := range config.OrganizationalUnits {
+		dn := ouDN(ou, domain)
+		if normalizeDN(dn) == normalizeDN(parent) {
+			return strings.TrimSuffix(dn, ","+domainDN(domain)), ldapResult{}
+		}
+	}
+
+	return "", noSuchObjectResult(config, parent)
+}
+
+// Update sequence numbers of a new object. Directory must be locked for writing.
+func newUSN(config *models.AppConfig) models.USN {
+	var usn models.USN
+	markChanged(config, &usn)
+	usn.USNCreated = usn.USNChanged
+	return usn
+}
@@ -0,0 +1,72 @@
+package ldap
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitDN(t *testing.T) {
+	tests := []struct {
+		dn     string
+		attr   string
+		value  string
+		parent string
+	}{
+		{"CN=user1,CN=Users,DC=example,DC=com", "CN", "user1", "CN=Users,DC=example,DC=com"},
+		{" cn = user1 , cn=Users", "cn", "user1", "cn=Users"},
+		{`CN=Doe\, John,CN=Users,DC=example,DC=com`, "CN", "Doe, John", "CN=Users,DC=example,DC=com"},
+		{`CN=Doe\2C John,CN=Users,DC=example,DC=com`, "CN", "Doe, John", "CN=Users,DC=example,DC=com"},
+		{`CN=back\\slash\,,OU=a\,b,DC=com`, "CN", `back\slash,`, `OU=a\,b,DC=com`},
+		{`CN=\#1 \+ \"quoted\"\;\<\>,DC=com`, "CN", `#1 + "quoted";<>`, "DC=com"},
+		{`CN=trailing\ ,DC=com`, "CN", "trailing ", "DC=com"},
+		{`CN=user\0ADEL:guid,CN=Deleted Objects`, "CN", "user\nDEL:guid", "CN=Deleted Objects"},
+		{"DC=com", "DC", "com", ""},
+	}
+
+	for _, tt := range tests {
+		attr, value, parent := splitDN(tt.dn)
+		if attr != tt.attr || value != tt.value || parent != tt.parent {
+			t.Errorf("splitDN(%q) = %q, %q, %q, want %q, %q, %q", tt.dn, attr, value, parent, tt.attr, tt.value, tt.parent)
+		}
+	}
+}
+
+func TestEscapeRDNValue(t *testing.T) {
+	tests := map[string]string{
+		"user1":          "user1",
+		"Doe, John":      `Doe\, John`,
+		`a\b+c"d;e<f>g`:  `a\\b\+c\"d\;e\<f\>g`,
+		"#hash":          `\#hash`,
+		" spaces ":       `\ spaces\ `,
+		"user\nDEL:guid": `user\0ADEL:guid`,
+		"Müller, Jürgen": `Müller\, Jürgen`,
+	}
+
+	for value, expected := range tests {
+		if escaped := escapeRDNValue(value); escaped != expected {
+			t.Errorf("escapeRDNValue(%q) = %q, want %q", value, escaped, expected)
+		}
+		if unescaped := unescapeRDNValue(expected); unescaped != value {
+			t.Errorf("unescapeRDNValue(%q) = %q, want %q", expected, unescaped, value)
+		}
+	}
+}
+
+func TestNormalizeDNEscapes(t *testing.T) {
+	if normalizeDN(`CN=Doe\, John, CN=Users,DC=example,DC=com`) != normalizeDN(`cn=doe\2c john,cn=users,dc=example,dc=com`) {
+		t.Error("differently escaped names should be equal")
+	}
+	if normalizeDN(`CN=a\,b,DC=com`) == normalizeDN("CN=a,CN=b,DC=com") {
+		t.Error("escaped comma should not separate names")
+	}
+
+	if parents := parentDNs(`CN=Doe\, John,OU=Staff,DC=com`); !slices.Equal(parents, []string{"OU=Staff,DC=com", "DC=com"}) {
+		t.Errorf("parentDNs() = %v", parents)
+	}
+	if isSameOrUnder(`CN=a\,OU=Staff,DC=com`, "OU=Staff,DC=com") {
+		t.Error("object with escaped comma in its name should not be under OU")
+	}
+	if !isSameOrUnder(`CN=a\,b,OU=Staff,DC=com`, "ou=staff, dc=com") {
+		t.Error("object should be under its OU")
+	}
+}
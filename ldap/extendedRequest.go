@@ -0,0 +1,179 @@
+package ldap
+
+import (
+	"log"
+	"smad/models"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+const (
+	cancelOID   = "1.3.6.1.1.8"
+	startTLSOID = "1.3.6.1.4.1.1466.20037"
+	whoAmIOID   = "1.3.6.1.4.1.4203.1.11.3"
+)
+
+type extendedResult struct {
+	statusCode    int
+	errorMessage  string
+	responseName  string
+	responseValue []byte
+
+	// Called after the response has been sent to the client
+	afterResponse func()
+}
+
+type extendedOperation func(sess *Session, requestValue []byte, config *models.AppConfig) extendedResult
+
+// Supported extended operations, by request name
+var extendedOperations = map[string]extendedOperation{
+	cancelOID:   cancelOperation,
+	startTLSOID: startTLS,
+	whoAmIOID:   whoAmI,
+
+	passwordModifyOID: passwordModify,
+}
+
+func createExtendedResponsePkg(statusCode int, errorMessage string, responseName string, responseValue []byte) *ber.Packet {
+	extRspPacket := createResultPkg(0x18, statusCode, "", errorMessage)
+
+	if responseName != "" {
+		namePacket := ber.NewString(ber.ClassContext, ber.TypePrimitive, 0x0a, responseName, "")
+		extRspPacket.AppendChild(namePacket)
+	}
+
+	if responseValue != nil {
+		valuePacket := ber.NewString(ber.ClassContext, ber.TypePrimitive, 0x0b, string(responseValue), "")
+		extRspPacket.AppendChild(valuePacket)
+	}
+
+	return extRspPacket
+}
+
+// Cancel operation (RFC 3909): stops outstanding operation, which then responds with
+// 'canceled' result, and tells the client whether that succeeded
+func cancelOperation(sess *Session, requestValue []byte, config *models.AppConfig) extendedResult {
+	valuePacket, err := ber.DecodePacketErr(requestValue)
+	if err != nil || len(valuePacket.Children) != 1 {
+		return extendedResult{statusCode: ResultProtocolError, errorMessage: DecodingErrorMessage}
+	}
+
+	cancelId, err := ParseMessageID(valuePacket.Children[0])
+	if err != nil {
+		return extendedResult{statusCode: ResultProtocolError, errorMessage: DecodingErrorMessage}
+	}
+
+	// Bind, unbind, abandon and extended operations are processed before next request is
+	// read, so they are never outstanding here
+	op := sess.findOperation(cancelId)
+	if op == nil {
+		return extendedResult{statusCode: ResultNoSuchOperation}
+	}
+
+	if !op.stop(errCanceled) {
+		return extendedResult{statusCode: ResultTooLate}
+	}
+
+	// Wait for operation to send its response, cancel response must come after it. Operations
+	// which complete without checking for cancellation were not canceled after all.
+	<-op.done
+	if !op.aborted {
+		return extendedResult{statusCode: ResultTooLate}
+	}
+
+	return extendedResult{statusCode: ResultSuccess}
+}
+
+// StartTLS (RFC 4511, section 4.14): response is sent in plain, after which TLS handshake
+// starts on the same connection
+func startTLS(sess *Session, requestValue []byte, config *models.AppConfig) extendedResult {
+	rsp := extendedResult{responseName: startTLSOID}
+
+	if config.TLSConfig == nil {
+		rsp.statusCode = ResultUnavailable
+		rsp.errorMessage = "00000000: LdapErr: DSID-0C0911E7, comment: Error initializing SSL/TLS, data 0, v4563"
+	} else if sess.isTLS() {
+		rsp.statusCode = ResultOperationsError
+		rsp.errorMessage = "00000000: LdapErr: DSID-0C0911E7, comment: TLS or SSL already in effect, data 0, v4563"
+	} else if sess.outstandingOperations() > 0 {
+		rsp.statusCode = ResultOperationsError
+		rsp.errorMessage = "00000000: LdapErr: DSID-0C0911E7, comment: Outstanding operations on connection, data 0, v4563"
+	} else {
+		rsp.afterResponse = func() {
+			if err := sess.startTLS(config.TLSConfig); err != nil {
+				// Connection is in unknown state after failed handshake, so give up on it
+				log.Println("StartTLS handshake failed", err)
+				sess.Close()
+			}
+		}
+	}
+
+	return rsp
+}
+
+// Pre-windows 2000 style logon name of the user. Users don't necessarily have explicit
+// sAMAccountName attribute, in which case the name part of userPrincipalName is used.
+func samAccountName(user models.User) string {
+	if name := user.Attributes["sAMAccountName"]; name != "" {
+		return name
+	}
+
+	name, _, _ := strings.Cut(user.Upn, "@")
+	return name
+}
+
+// NetBIOS name of the domain, which is the first component of the domain name
+func netbiosName(domain string) string {
+	name, _, _ := strings.Cut(domain, ".")
+	return strings.ToUpper(name)
+}
+
+// Who am I? operation (RFC 4532): tells the authorization identity of the connection, in same
+// 'u:DOMAIN\user' form as AD. Anonymous connection has empty identity.
+func whoAmI(sess *Session, requestValue []byte, config *models.AppConfig) extendedResult {
+	authzId := ""
+
+	config.RLock()
+	defer config.RUnlock()
+
+	if userIdx := boundUserIndex(config, sess); userIdx >= 0 {
+		authzId = "u:" + netbiosName(config.Configuration.Domain) + "\\" + samAccountName(config.Users[userIdx])
+	}
+
+	return extendedResult{responseValue: []byte(authzId)}
+}
+
+// Extended requests are processed before reading the next request, since StartTLS must
+// not let anything else use the connection until the TLS handshake is done
+func HandleExtendedRequest(sess *Session, p *ber.Packet, msgNum int64, config *models.AppConfig) {
+	if len(p.Children) == 0 || p.Children[0].Data == nil {
+		log.Println("Unsupported extended request package")
+		return
+	}
+
+	requestName := p.Children[0].Data.String()
+	var requestValue []byte
+	if len(p.Children) > 1 && p.Children[1].Data != nil {
+		requestValue = p.Children[1].Data.Bytes()
+	}
+
+	result := extendedResult{
+		statusCode:   ResultProtocolError,
+		errorMessage: "0000203D: LdapErr: DSID-0C0904B6, comment: Unknown extended request OID, data 0, v4563",
+	}
+
+	if operation, ok := extendedOperations[requestName]; ok {
+		result = operation(sess, requestValue, config)
+	} else {
+		log.Printf("Unsupported extended request %s\n", requestName)
+	}
+
+	rsp := createResponsePacket(msgNum)
+	rsp.AppendChild(createExtendedResponsePkg(result.statusCode, result.errorMessage, result.responseName, result.responseValue))
+	sess.Write(rsp.Bytes())
+
+	if result.afterResponse != nil {
+		result.afterResponse()
+	}
+}
@@ -0,0 +1,276 @@
+package ldap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to create extended request with given name and value
+func createExtendedRequestPacket(requestName string, requestValue *ber.Packet) *ber.Packet {
+	extReq := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 0x17, nil, "")
+	extReq.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, requestName, ""))
+	if requestValue != nil {
+		extReq.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 1, string(requestValue.Bytes()), ""))
+	}
+	return extReq
+}
+
+// Helper function to create cancel extended request for given message id
+func createCancelRequestPacket(cancelId int64) *ber.Packet {
+	value := ber.NewSequence("")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, cancelId, ""))
+	return createExtendedRequestPacket(cancelOID, value)
+}
+
+// Helper function to decode result code of the last response written to connection
+func lastResultCode(t *testing.T, conn *mocks.MockConn) int64 {
+	messages := decodeMessages(t, conn)
+	if len(messages) == 0 {
+		t.Fatal("no response written")
+	}
+	last := messages[len(messages)-1]
+	return last.Children[1].Children[0].Value.(int64)
+}
+
+func TestCancelOutstandingOperation(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+	startBlockingOperation(sess, 5, 3)
+
+	HandleExtendedRequest(sess, createCancelRequestPacket(5), 6, &models.AppConfig{})
+
+	// Canceled operation responds first with 'canceled', then cancel itself succeeds
+	messages := decodeMessages(t, conn)
+	if len(messages) != 2 {
+		t.Fatalf("cancel should produce 2 responses, got %d", len(messages))
+	}
+	if messages[0].Children[0].Value != int64(5) || messages[0].Children[1].Children[0].Value != int64(ResultCanceled) {
+		t.Error("canceled operation should respond with canceled result")
+	}
+	if messages[1].Children[0].Value != int64(6) || messages[1].Children[1].Children[0].Value != int64(ResultSuccess) {
+		t.Error("cancel response should be successful")
+	}
+}
+
+func TestCancelUnknownOperation(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+
+	HandleExtendedRequest(sess, createCancelRequestPacket(42), 2, &models.AppConfig{})
+
+	if code := lastResultCode(t, conn); code != ResultNoSuchOperation {
+		t.Errorf("cancel of unknown operation = %d, want noSuchOperation", code)
+	}
+}
+
+func TestCancelTooLate(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+
+	// Operation which has already started sending its final response
+	started := make(chan bool)
+	release := make(chan bool)
+	op := NewOperation(7, 3)
+	sess.Run(op, func() {
+		op.finish()
+		started <- true
+		<-release
+	})
+	<-started
+
+	HandleExtendedRequest(sess, createCancelRequestPacket(7), 8, &models.AppConfig{})
+	close(release)
+	sess.Wait()
+
+	if code := lastResultCode(t, conn); code != ResultTooLate {
+		t.Errorf("cancel of finishing operation = %d, want tooLate", code)
+	}
+}
+
+func TestHandleExtendedRequestUnknownOID(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+
+	HandleExtendedRequest(sess, createExtendedRequestPacket("1.2.3.4", nil), 3, &models.AppConfig{})
+
+	if code := lastResultCode(t, conn); code != ResultProtocolError {
+		t.Errorf("unknown extended request = %d, want protocolError", code)
+	}
+}
+
+// Helper function to create TLS configuration with self signed certificate
+func createTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestStartTLS(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	sess := NewSession(serverConn)
+	config := &models.AppConfig{TLSConfig: createTestTLSConfig(t)}
+
+	done := make(chan bool)
+	go func() {
+		HandleExtendedRequest(sess, createExtendedRequestPacket(startTLSOID, nil), 1, config)
+		done <- true
+	}()
+
+	// Response comes in plain text
+	rsp, err := ReadMessage(clientConn, 0)
+	if err != nil {
+		t.Fatalf("failed to read StartTLS response: %v", err)
+	}
+	if rsp.Children[1].Children[0].Value != int64(ResultSuccess) {
+		t.Fatalf("StartTLS result = %v, want success", rsp.Children[1].Children[0].Value)
+	}
+
+	// After which the same connection is upgraded
+	tlsClient := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsClient.Handshake(); err != nil {
+		t.Fatalf("TLS handshake failed: %v", err)
+	}
+	<-done
+
+	if !sess.isTLS() {
+		t.Error("session should use TLS after StartTLS")
+	}
+
+	// Requests are now read through TLS
+	go tlsClient.Write(createEncodedMessage(2, 10))
+	p, err := sess.ReadMessage(0)
+	if err != nil || p.Children[0].Value != int64(2) {
+		t.Errorf("reading request over TLS failed: %v", err)
+	}
+}
+
+func TestStartTLSNotConfigured(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+
+	HandleExtendedRequest(sess, createExtendedRequestPacket(startTLSOID, nil), 1, &models.AppConfig{})
+
+	if code := lastResultCode(t, conn); code != ResultUnavailable {
+		t.Errorf("StartTLS without certificate = %d, want unavailable", code)
+	}
+}
+
+func TestStartTLSOutstandingOperations(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+	op := startBlockingOperation(sess, 1, 3)
+
+	HandleExtendedRequest(sess, createExtendedRequestPacket(startTLSOID, nil), 2, &models.AppConfig{TLSConfig: createTestTLSConfig(t)})
+	op.stop(errAbandoned)
+	sess.Wait()
+
+	if code := lastResultCode(t, conn); code != ResultOperationsError {
+		t.Errorf("StartTLS with outstanding operations = %d, want operationsError", code)
+	}
+}
+
+// Helper function to decode response value of the last extended response
+func lastResponseValue(t *testing.T, conn *mocks.MockConn) (string, bool) {
+	messages := decodeMessages(t, conn)
+	if len(messages) == 0 {
+		t.Fatal("no response written")
+	}
+	for _, child := range messages[len(messages)-1].Children[1].Children {
+		if child.ClassType == ber.ClassContext && child.Tag == 0x0b {
+			return child.Data.String(), true
+		}
+	}
+	return "", false
+}
+
+func TestWhoAmI(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("Test User", "test.user@example.com", "testpass", nil, map[string]string{}),
+		createTestUser("Service", "svc@example.com", "testpass", nil, map[string]string{"sAMAccountName": "svc_app"}),
+	}, nil)
+
+	// Bind as first user
+	bindReq := createLDAPMessageWithBindRequest("test.user@example.com", "testpass", 1)
+	HandleBindRequest(sess, bindReq.Children[1], 1, config)
+
+	HandleExtendedRequest(sess, createExtendedRequestPacket(whoAmIOID, nil), 2, config)
+	if value, _ := lastResponseValue(t, conn); value != "u:EXAMPLE\\test.user" {
+		t.Errorf("WhoAmI = '%s', want 'u:EXAMPLE\\test.user'", value)
+	}
+
+	// Explicit sAMAccountName is preferred over userPrincipalName
+	bindReq = createLDAPMessageWithBindRequest("svc@example.com", "testpass", 3)
+	HandleBindRequest(sess, bindReq.Children[1], 3, config)
+
+	HandleExtendedRequest(sess, createExtendedRequestPacket(whoAmIOID, nil), 4, config)
+	if value, _ := lastResponseValue(t, conn); value != "u:EXAMPLE\\svc_app" {
+		t.Errorf("WhoAmI = '%s', want 'u:EXAMPLE\\svc_app'", value)
+	}
+}
+
+func TestWhoAmIAnonymous(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+	config := createTestConfig("example.com")
+
+	HandleExtendedRequest(sess, createExtendedRequestPacket(whoAmIOID, nil), 1, config)
+
+	if code := lastResultCode(t, conn); code != ResultSuccess {
+		t.Errorf("WhoAmI result = %d, want success", code)
+	}
+
+	if value, found := lastResponseValue(t, conn); !found || value != "" {
+		t.Error("WhoAmI should return empty identity for anonymous connection")
+	}
+}
+
+func TestWhoAmIAfterRename(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("Test User", "test.user@example.com", "testpass", nil, map[string]string{}),
+	}, nil)
+
+	bindReq := createLDAPMessageWithBindRequest("test.user@example.com", "testpass", 1)
+	HandleBindRequest(sess, bindReq.Children[1], 1, config)
+
+	// Bound user is still known after its userPrincipalName changes
+	if code := modifyEntry(t, config, nil, "CN=Test User,CN=Users,DC=example,DC=com", newModification(modifyReplace, "userPrincipalName", "renamed@example.com")); code != ResultSuccess {
+		t.Fatalf("modify returned %d", code)
+	}
+
+	HandleExtendedRequest(sess, createExtendedRequestPacket(whoAmIOID, nil), 2, config)
+	if value, _ := lastResponseValue(t, conn); value != "u:EXAMPLE\\renamed" {
+		t.Errorf("WhoAmI = '%s', want 'u:EXAMPLE\\renamed'", value)
+	}
+}
@@ -0,0 +1,81 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+var (
+	ErrMessageTooLarge  = errors.New("ldap message exceeds maximum size")
+	ErrMalformedMessage = errors.New("malformed ldap message")
+)
+
+func readFull(r io.Reader, buf []byte) error {
+	// Running out of data in the middle of a message means the message was truncated
+	_, err := io.ReadFull(r, buf)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Reads exactly one BER encoded LDAPMessage from the stream. Messages split over multiple
+// reads as well as several messages sent back-to-back are handled, since only the bytes
+// belonging to the current message are consumed. io.EOF is returned only when the stream
+// ends cleanly between two messages. Messages larger than maxSize (0 = no limit) are rejected
+// without reading the content.
+func ReadMessage(r io.Reader, maxSize int) (*ber.Packet, error) {
+	header := make([]byte, 2, 6)
+
+	// LDAPMessage is always a universal constructed sequence
+	if _, err := io.ReadFull(r, header[:1]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0x30 {
+		return nil, fmt.Errorf("%w: unexpected tag 0x%02x", ErrMalformedMessage, header[0])
+	}
+
+	if err := readFull(r, header[1:2]); err != nil {
+		return nil, err
+	}
+
+	length := uint64(header[1])
+	if header[1]&0x80 != 0 {
+		// Only definite length form is allowed (RFC 4511, section 5.1), and anything needing
+		// more than 4 length bytes wouldn't fit any sane message size anyway
+		lengthBytes := int(header[1] & 0x7f)
+		if lengthBytes == 0 || lengthBytes > 4 {
+			return nil, fmt.Errorf("%w: unsupported length encoding 0x%02x", ErrMalformedMessage, header[1])
+		}
+
+		header = header[:2+lengthBytes]
+		if err := readFull(r, header[2:]); err != nil {
+			return nil, err
+		}
+
+		length = 0
+		for _, b := range header[2:] {
+			length = length<<8 | uint64(b)
+		}
+	}
+
+	if maxSize > 0 && length > uint64(maxSize) {
+		return nil, fmt.Errorf("%w: %d bytes (max %d)", ErrMessageTooLarge, length, maxSize)
+	}
+
+	msg := make([]byte, len(header)+int(length))
+	copy(msg, header)
+	if err := readFull(r, msg[len(header):]); err != nil {
+		return nil, err
+	}
+
+	p, err := ber.DecodePacketErr(msg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+
+	return p, nil
+}
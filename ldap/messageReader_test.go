@@ -0,0 +1,110 @@
+package ldap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"smad/internal/mocks"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to create an encoded LDAP message with given message id and payload size
+func createEncodedMessage(msgNum int, payloadSize int) []byte {
+	msg := ber.NewSequence("")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgNum, ""))
+	payload := ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(bytes.Repeat([]byte("a"), payloadSize)), "")
+	msg.AppendChild(payload)
+	return msg.Bytes()
+}
+
+func TestReadMessageBackToBack(t *testing.T) {
+	// Two messages in single buffer, second one using long form length
+	data := append(createEncodedMessage(1, 10), createEncodedMessage(2, 5000)...)
+	reader := bytes.NewReader(data)
+
+	for _, expected := range []int64{1, 2} {
+		p, err := ReadMessage(reader, 0)
+		if err != nil {
+			t.Fatalf("ReadMessage() returned error: %v", err)
+		}
+		if p.Children[0].Value != expected {
+			t.Errorf("ReadMessage() message id = %v, want %d", p.Children[0].Value, expected)
+		}
+	}
+
+	// Stream ended cleanly between messages
+	if _, err := ReadMessage(reader, 0); err != io.EOF {
+		t.Errorf("ReadMessage() at end of stream = %v, want io.EOF", err)
+	}
+}
+
+func TestReadMessageSplitAcrossReads(t *testing.T) {
+	// Deliver the message one byte at a time
+	reader := iotest.OneByteReader(bytes.NewReader(createEncodedMessage(3, 300)))
+
+	p, err := ReadMessage(reader, 0)
+	if err != nil {
+		t.Fatalf("ReadMessage() returned error: %v", err)
+	}
+	if len(p.Children) != 2 || len(p.Children[1].Value.(string)) != 300 {
+		t.Error("ReadMessage() should decode the whole message")
+	}
+}
+
+func TestReadMessageTooLarge(t *testing.T) {
+	reader := bytes.NewReader(createEncodedMessage(4, 2000))
+
+	_, err := ReadMessage(reader, 1024)
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("ReadMessage() = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestReadMessageTruncated(t *testing.T) {
+	data := createEncodedMessage(5, 100)
+	reader := bytes.NewReader(data[:len(data)-10])
+
+	_, err := ReadMessage(reader, 0)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadMessage() = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestReadMessageMalformed(t *testing.T) {
+	// Not a sequence
+	_, err := ReadMessage(bytes.NewReader([]byte{0x04, 0x01, 0x61}), 0)
+	if !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("ReadMessage() = %v, want ErrMalformedMessage for wrong tag", err)
+	}
+
+	// Indefinite length
+	_, err = ReadMessage(bytes.NewReader([]byte{0x30, 0x80, 0x02, 0x01, 0x01, 0x00, 0x00}), 0)
+	if !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("ReadMessage() = %v, want ErrMalformedMessage for indefinite length", err)
+	}
+
+	// Inner element claims to be longer than the message
+	_, err = ReadMessage(bytes.NewReader([]byte{0x30, 0x03, 0x02, 0x05, 0x01}), 0)
+	if !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("ReadMessage() = %v, want ErrMalformedMessage for invalid inner length", err)
+	}
+}
+
+func TestSendNoticeOfDisconnection(t *testing.T) {
+	conn := mocks.NewMockConn()
+
+	SendNoticeOfDisconnection(conn, ResultProtocolError, "test message")
+
+	p := ber.DecodePacket(conn.GetWrittenData())
+	if p.Children[0].Value != int64(0) {
+		t.Error("notice of disconnection should use message id 0")
+	}
+	if p.Children[1].Tag != 0x18 || p.Children[1].Children[0].Value != int64(ResultProtocolError) {
+		t.Error("notice of disconnection should be extended response with protocolError")
+	}
+	assertResponseContains(t, conn, "SendNoticeOfDisconnection", []byte(noticeOfDisconnectionOID))
+}
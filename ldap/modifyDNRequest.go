@@ -0,0 +1,181 @@
+package ldap
+
+import (
+	"slices"
+	"smad/models"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Containers relative to the domain are compared like distinguished names
+func isSameOrUnder(container, parent string) bool {
+	rdns, parentRDNs := splitRDNs(normalizeDN(container)), splitRDNs(normalizeDN(parent))
+	return len(rdns) >= len(parentRDNs) && slices.Equal(rdns[len(rdns)-len(parentRDNs):], parentRDNs)
+}
+
+// Moves everything in renamed or moved OU with it. Objects only know their container, so
+// distinguished names referring to them (like memberOf and member) follow automatically.
+// Moved objects are recorded as changed, so that synchronizing clients see their new names.
+// Directory must be locked for writing.
+func moveContainerChildren(config *models.AppConfig, oldContainer, newContainer string) {
+	move := func(container *string, usn *models.USN) bool {
+		if *container == "" || !isSameOrUnder(*container, oldContainer) {
+			return false
+		}
+		*container = (*container)[:len(*container)-len(oldContainer)] + newContainer
+		markChanged(config, usn)
+		return true
+	}
+
+	for idx := range config.Users {
+		if user := &config.Users[idx]; move(&user.Container, &user.USN) {
+			notifyChange(config, userElement(config, *user))
+		}
+	}
+	for idx := range config.Groups {
+		if group := &config.Groups[idx]; move(&group.Container, &group.USN) {
+			notifyChange(config, groupElement(config, *group))
+		}
+	}
+	for idx := range config.OrganizationalUnits {
+		if ou := &config.OrganizationalUnits[idx]; move(&ou.Container, &ou.USN) {
+			notifyChange(config, ouElement(config, *ou))
+		}
+	}
+}
+
+// Renames the object and moves it to new parent, if one is given. Directory must be locked
+// for writing.
+func modifyDN(config *models.AppConfig, dn, newRDN string, deleteOldRDN bool, newSuperior *string) ldapResult {
+	domain := config.Configuration.Domain
+
+	userIdx := findUserIndex(config, "dn:"+dn)
+	groupIdx := findGroupIndex(config, dn)
+	ouIdx := findOUIndex(config, dn)
+	if userIdx < 0 && groupIdx < 0 && ouIdx < 0 {
+		return noSuchObjectResult(config, dn)
+	}
+	isOU := ouIdx >= 0
+
+	rdnAttribute, name, rest := splitDN(newRDN)
+	if name == "" || rest != "" {
+		return ldapResult{statusCode: ResultInvalidDNSyntax, errorMessage: invalidDNSyntaxMessage(newRDN)}
+	}
+
+	_, oldName, parent := splitDN(dn)
+	if (isOU && !strings.EqualFold(rdnAttribute, "OU")) || (!isOU && !strings.EqualFold(rdnAttribute, "CN")) {
+		return ldapResult{statusCode: ResultNamingViolation, errorMessage: namingViolationMessage(parent)}
+	}
+
+	// Naming attributes have single value, so old name can't be kept with the new one
+	if !deleteOldRDN && !strings.EqualFold(oldName, name) {
+		return ldapResult{statusCode: ResultUnwillingToPerform, errorMessage: willNotPerformMessage}
+	}
+
+	if newSuperior != nil {
+		parent = *newSuperior
+	}
+
+	// OU can't be moved under itself
+	if isOU && isSameOrUnder(parent, dn) {
+		return ldapResult{statusCode: ResultUnwillingToPerform, errorMessage: willNotPerformMessage}
+	}
+
+	container, result := resolveContainer(config, parent, isOU)
+	if result.statusCode != ResultSuccess {
+		return result
+	}
+
+	newDN := rdnAttribute + "=" + escapeRDNValue(name) + "," + parent
+	if entryExists(config, newDN) && normalizeDN(newDN) != normalizeDN(dn) {
+		return ldapResult{statusCode: ResultEntryAlreadyExists, errorMessage: entryExistsMessage}
+	}
+
+	switch {
+	case userIdx >= 0:
+		user := &config.Users[userIdx]
+		user.Cn = name
+		user.Container = container
+		if user.Attributes == nil {
+			user.Attributes = make(map[string]string)
+		}
+		user.Attributes["name"] = name
+		markChanged(config, &user.USN, "cn", "name")
+		notifyChange(config, userElement(config, *user))
+
+	case groupIdx >= 0:
+		// Users refer to groups by name, so group names must be unique
+		oldName := config.Groups[groupIdx].Cn
+		if !strings.EqualFold(oldName, name) && slices.ContainsFunc(config.Groups, func(c models.Group) bool { return strings.EqualFold(c.Cn, name) }) {
+			return ldapResult{statusCode: ResultEntryAlreadyExists, errorMessage: entryExistsMessage}
+		}
+
+		for idx := range config.Users {
+			for groupRef, group := range config.Users[idx].Groups {
+				if group == oldName {
+					config.Users[idx].Groups[groupRef] = name
+				}
+			}
+		}
+
+		group := &config.Groups[groupIdx]
+		group.Cn = name
+		group.Container = container
+		markChanged(config, &group.USN, "cn", "name")
+		notifyChange(config, groupElement(config, *group))
+
+	default:
+		ou := &config.OrganizationalUnits[ouIdx]
+		oldContainer := strings.TrimSuffix(ouDN(*ou, domain), ","+domainDN(domain))
+		ou.Ou = name
+		ou.Container = container
+		newContainer := strings.TrimSuffix(ouDN(*ou, domain), ","+domainDN(domain))
+
+		moveContainerChildren(config, oldContainer, newContainer)
+		markChanged(config, &ou.USN, "ou", "name")
+		notifyChange(config, ouElement(config, *ou))
+	}
+
+	return ldapResult{}
+}
+
+// Renames an object or moves it to another container (RFC 4511, section 4.9). Old RDN must
+// be removed when object is renamed, because naming attributes have single value.
+func HandleModifyDNRequest(sess *Session, p *ber.Packet, op *Operation, config *models.AppConfig) {
+	if len(p.Children) < 3 {
+		sendResult(sess, op.ID, 0x0d, ldapResult{statusCode: ResultProtocolError, errorMessage: DecodingErrorMessage})
+		return
+	}
+	deleteOldRDN, ok := p.Children[2].Value.(bool)
+	if !ok {
+		sendResult(sess, op.ID, 0x0d, ldapResult{statusCode: ResultProtocolError, errorMessage: DecodingErrorMessage})
+		return
+	}
+
+	if !sess.BindSuccessful {
+		sendResult(sess, op.ID, 0x0d, ldapResult{statusCode: ResultInsufficientAccessRights, errorMessage: insufficientAccessMessage})
+		return
+	}
+
+	dn := p.Children[0].Data.String()
+	newRDN := p.Children[1].Data.String()
+
+	var newSuperior *string
+	if len(p.Children) > 3 && p.Children[3].ClassType == ber.ClassContext && p.Children[3].Tag == 0 {
+		superior := p.Children[3].Data.String()
+		newSuperior = &superior
+	}
+
+	// Once the directory is changed, it's too late to stop
+	if err := op.finish(); err != nil {
+		sendStoppedResult(sess, op.ID, 0x0d, err)
+		return
+	}
+
+	config.Lock()
+	result := modifyDN(config, dn, newRDN, deleteOldRDN, newSuperior)
+	config.Unlock()
+
+	sendResult(sess, op.ID, 0x0d, result)
+}
@@ -0,0 +1,217 @@
+package ldap
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to create modify DN request packet
+func createModifyDNRequestPacket(dn, newRDN string, deleteOldRDN bool, newSuperior string) *ber.Packet {
+	modifyDNReq := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 0x0c, nil, "")
+	modifyDNReq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+	modifyDNReq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, newRDN, ""))
+	modifyDNReq.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, deleteOldRDN, ""))
+	if newSuperior != "" {
+		modifyDNReq.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, newSuperior, ""))
+	}
+	return modifyDNReq
+}
+
+// Helper function to run modify DN request, returns result code
+func modifyDNEntry(t *testing.T, config *models.AppConfig, dn, newRDN, newSuperior string) int64 {
+	conn := mocks.NewMockConn()
+	HandleModifyDNRequest(createTestSession(conn, true), createModifyDNRequestPacket(dn, newRDN, true, newSuperior), NewOperation(1, 12), config)
+	return lastResultCode(t, conn)
+}
+
+// Helper function to find distinguished names of object's attribute
+func objectReferences(t *testing.T, config *models.AppConfig, dn string, attribute string) []string {
+	config.RLock()
+	defer config.RUnlock()
+
+	object, ok := findObject(config, dn)
+	if !ok {
+		t.Fatalf("object %s not found", dn)
+	}
+	return attributeValues(object, attribute)
+}
+
+func createModifyDNTestConfig() *models.AppConfig {
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("user1", "user1@example.com", "pass", []string{"group1"}, map[string]string{"name": "user1"}),
+		createTestUser("user2", "user2@example.com", "pass", nil, nil),
+	}, []models.Group{createTestGroup("group1")})
+	config.OrganizationalUnits = []models.OrganizationalUnit{{Ou: "Disabled"}, {Ou: "Staff"}}
+	return config
+}
+
+func TestModifyDNRenameUser(t *testing.T) {
+	config := createModifyDNTestConfig()
+
+	if code := modifyDNEntry(t, config, "CN=user1,CN=Users,DC=example,DC=com", "CN=renamed", ""); code != ResultSuccess {
+		t.Fatalf("rename returned %d", code)
+	}
+	if config.Users[0].Cn != "renamed" || config.Users[0].Attributes["name"] != "renamed" {
+		t.Errorf("user should be renamed, got %+v", config.Users[0])
+	}
+
+	members := objectReferences(t, config, "CN=group1,CN=Users,DC=example,DC=com", "member")
+	if !slices.Equal(members, []string{"CN=renamed,CN=Users,DC=example,DC=com"}) {
+		t.Errorf("group member should follow rename, got %v", members)
+	}
+}
+
+func TestModifyDNMoveUser(t *testing.T) {
+	config := createModifyDNTestConfig()
+
+	if code := modifyDNEntry(t, config, "CN=user1,CN=Users,DC=example,DC=com", "CN=user1", "OU=Disabled,DC=example,DC=com"); code != ResultSuccess {
+		t.Fatalf("move returned %d", code)
+	}
+
+	members := objectReferences(t, config, "CN=group1,CN=Users,DC=example,DC=com", "member")
+	if !slices.Equal(members, []string{"CN=user1,OU=Disabled,DC=example,DC=com"}) {
+		t.Errorf("group member should follow move, got %v", members)
+	}
+
+	// Moved user can still be found by new name
+	if idx := findUserIndex(config, "CN=user1,OU=Disabled,DC=example,DC=com"); idx != 0 {
+		t.Errorf("moved user not found by new DN")
+	}
+}
+
+func TestModifyDNRenameGroup(t *testing.T) {
+	config := createModifyDNTestConfig()
+
+	if code := modifyDNEntry(t, config, "CN=group1,CN=Users,DC=example,DC=com", "CN=developers", "OU=Staff,DC=example,DC=com"); code != ResultSuccess {
+		t.Fatalf("rename returned %d", code)
+	}
+
+	memberOf := objectReferences(t, config, "CN=user1,CN=Users,DC=example,DC=com", "memberOf")
+	if !slices.Equal(memberOf, []string{"CN=developers,OU=Staff,DC=example,DC=com"}) {
+		t.Errorf("memberOf should follow rename, got %v", memberOf)
+	}
+}
+
+func TestModifyDNRenameOU(t *testing.T) {
+	config := createModifyDNTestConfig()
+	config.OrganizationalUnits = append(config.OrganizationalUnits, models.OrganizationalUnit{Ou: "Helsinki", Container: "OU=Staff"})
+	config.Users[1].Container = "OU=Helsinki,OU=Staff"
+
+	if code := modifyDNEntry(t, config, "OU=Staff,DC=example,DC=com", "OU=People", ""); code != ResultSuccess {
+		t.Fatalf("rename returned %d", code)
+	}
+	if config.Users[1].Container != "OU=Helsinki,OU=People" || config.OrganizationalUnits[2].Container != "OU=People" {
+		t.Errorf("children should move with OU, got %s and %s", config.Users[1].Container, config.OrganizationalUnits[2].Container)
+	}
+
+	// OU can't be moved under itself
+	if code := modifyDNEntry(t, config, "OU=People,DC=example,DC=com", "OU=People", "OU=Helsinki,OU=People,DC=example,DC=com"); code != ResultUnwillingToPerform {
+		t.Errorf("moving OU under itself returned %d, want unwillingToPerform", code)
+	}
+}
+
+func TestModifyDNMovedChildrenChange(t *testing.T) {
+	config := createModifyDNTestConfig()
+	config.OrganizationalUnits = append(config.OrganizationalUnits, models.OrganizationalUnit{Ou: "Helsinki", Container: "OU=Staff"})
+	config.Users[1].Container = "OU=Helsinki,OU=Staff"
+
+	conn := mocks.NewMockConn()
+	sess := createTestSession(conn, true)
+	startNotificationRequest(t, sess, 3, config, createSearchRequestPacket("DC=example,DC=com", "(objectClass=person)"))
+
+	if code := modifyDNEntry(t, config, "OU=Staff,DC=example,DC=com", "OU=People", ""); code != ResultSuccess {
+		t.Fatalf("rename returned %d", code)
+	}
+
+	// Children have new names, so they are changed like the OU itself
+	if config.Users[1].USNChanged != config.HighestUSN-2 || config.OrganizationalUnits[2].USNChanged != config.HighestUSN-1 || config.OrganizationalUnits[1].USNChanged != config.HighestUSN {
+		t.Errorf("moved children should be changed before the OU, got USNs %d and %d", config.Users[1].USNChanged, config.OrganizationalUnits[2].USNChanged)
+	}
+	if config.Users[0].USNChanged != 0 {
+		t.Error("user outside OU should not change")
+	}
+
+	for i := 0; i < 100 && writtenLength(sess, conn) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	HandleAbandonRequest(sess, ber.NewInteger(ber.ClassApplication, ber.TypePrimitive, 16, 3, ""))
+	sess.Wait()
+
+	messages := decodeMessages(t, conn)
+	if len(messages) != 1 || messages[0].Children[1].Children[0].Value != "CN=user2,OU=Helsinki,OU=People,DC=example,DC=com" {
+		t.Errorf("notification search should send moved user, got %d messages", len(messages))
+	}
+}
+
+func TestModifyDNKeepOldRDN(t *testing.T) {
+	config := createModifyDNTestConfig()
+
+	conn := mocks.NewMockConn()
+	HandleModifyDNRequest(createTestSession(conn, true), createModifyDNRequestPacket("CN=user1,CN=Users,DC=example,DC=com", "CN=renamed", false, ""), NewOperation(1, 12), config)
+	if code := lastResultCode(t, conn); code != ResultUnwillingToPerform || config.Users[0].Cn != "user1" {
+		t.Errorf("rename keeping old name returned %d, want unwillingToPerform", code)
+	}
+
+	// Moving keeps the same name, so there's nothing to delete
+	conn = mocks.NewMockConn()
+	HandleModifyDNRequest(createTestSession(conn, true), createModifyDNRequestPacket("CN=user1,CN=Users,DC=example,DC=com", "CN=user1", false, "OU=Staff,DC=example,DC=com"), NewOperation(1, 12), config)
+	if code := lastResultCode(t, conn); code != ResultSuccess || config.Users[0].Container != "OU=Staff" {
+		t.Errorf("move keeping old name returned %d", code)
+	}
+}
+
+func TestModifyDNEscapedName(t *testing.T) {
+	config := createModifyDNTestConfig()
+
+	if code := modifyDNEntry(t, config, "CN=user1,CN=Users,DC=example,DC=com", `CN=Doe\, John`, ""); code != ResultSuccess {
+		t.Fatalf("rename returned %d", code)
+	}
+	if config.Users[0].Cn != "Doe, John" || config.Users[0].Attributes["name"] != "Doe, John" {
+		t.Errorf("user should be renamed without escapes, got %+v", config.Users[0])
+	}
+
+	members := objectReferences(t, config, "CN=group1,CN=Users,DC=example,DC=com", "member")
+	if !slices.Equal(members, []string{`CN=Doe\, John,CN=Users,DC=example,DC=com`}) {
+		t.Errorf("group member should have escaped name, got %v", members)
+	}
+
+	// Escaped name can be moved, and it doesn't end up in OU named like the rest of it
+	if code := modifyDNEntry(t, config, `CN=Doe\2C John,CN=Users,DC=example,DC=com`, `CN=Doe\, John`, "OU=Staff,DC=example,DC=com"); code != ResultSuccess {
+		t.Fatalf("move returned %d", code)
+	}
+	if config.Users[0].Container != "OU=Staff" {
+		t.Errorf("user should be moved to OU, got container %s", config.Users[0].Container)
+	}
+}
+
+func TestModifyDNErrors(t *testing.T) {
+	config := createModifyDNTestConfig()
+
+	tests := []struct {
+		name        string
+		dn          string
+		newRDN      string
+		newSuperior string
+		expected    int64
+	}{
+		{"missing object", "CN=nobody,CN=Users,DC=example,DC=com", "CN=somebody", "", ResultNoSuchObject},
+		{"existing name", "CN=user1,CN=Users,DC=example,DC=com", "CN=user2", "", ResultEntryAlreadyExists},
+		{"missing superior", "CN=user1,CN=Users,DC=example,DC=com", "CN=user1", "OU=Missing,DC=example,DC=com", ResultNoSuchObject},
+		{"wrong naming attribute", "CN=user1,CN=Users,DC=example,DC=com", "OU=user1", "", ResultNamingViolation},
+		{"built-in container", "CN=user1,CN=Users,DC=example,DC=com", "CN=Users", "DC=example,DC=com", ResultEntryAlreadyExists},
+		{"configuration container", "CN=user1,CN=Users,DC=example,DC=com", "CN=Configuration", "DC=example,DC=com", ResultEntryAlreadyExists},
+		{"deleted objects container", "CN=group1,CN=Users,DC=example,DC=com", "CN=Deleted Objects", "DC=example,DC=com", ResultEntryAlreadyExists},
+	}
+
+	for _, tt := range tests {
+		if code := modifyDNEntry(t, config, tt.dn, tt.newRDN, tt.newSuperior); code != tt.expected {
+			t.Errorf("%s: modify DN returned %d, want %d", tt.name, code, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package ldap
+
+import (
+	"maps"
+	"slices"
+	"smad/models"
+	"strconv"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// With permissive modify control, adding existing value or deleting missing value succeeds
+const permissiveModifyOID = "1.2.840.113556.1.4.1413"
+
+// Modify operations (RFC 4511, section 4.6), increment is from RFC 4525
+const (
+	modifyAdd       = 0
+	modifyDelete    = 1
+	modifyReplace   = 2
+	modifyIncrement = 3
+)
+
+type modification struct {
+	operation int64
+	partialAttribute
+}
+
+func attributeExistsMessage(attribute string) string {
+	return "00002083: AtrErr: DSID-03151818, #1:\n\t0: 00002083: DSID-03151818, problem 1006 (ATT_OR_VALUE_EXISTS), data 0, Att 0 (" + attribute + ")\n"
+}
+
+const notAllowedOnRDNMessage = "00002016: UpdErr: DSID-030F1AA9, problem 6004 (CANT_ON_RDN), data 0"
+
+// Applies the modification to current values of an attribute, values are matched with the
+// given function. Returns the new values.
+func modifyValues(current []string, mod modification, permissive bool, matches func(a, b string) bool) ([]string, ldapResult) {
+	contains := func(values []string, value string) bool {
+		return slices.ContainsFunc(values, func(v string) bool { return matches(v, value) })
+	}
+
+	switch mod.operation {
+	case modifyAdd:
+		values := slices.Clone(current)
+		for _, value := range mod.values {
+			if contains(values, value) {
+				if permissive {
+					continue
+				}
+				return nil, ldapResult{statusCode: ResultAttributeOrValueExists, errorMessage: attributeExistsMessage(mod.name)}
+			}
+			values = append(values, value)
+		}
+		return values, ldapResult{}
+
+	case modifyDelete:
+		// Delete without values removes the whole attribute
+		if len(mod.values) == 0 {
+			if len(current) == 0 && !permissive {
+				return nil, ldapResult{statusCode: ResultNoSuchAttribute, errorMessage: noSuchAttributeMessage(mod.name)}
+			}
+			return nil, ldapResult{}
+		}
+
+		values := slices.Clone(current)
+		for _, value := range mod.values {
+			if !contains(values, value) {
+				if permissive {
+					continue
+				}
+				return nil, ldapResult{statusCode: ResultNoSuchAttribute, errorMessage: noSuchAttributeMessage(mod.name)}
+			}
+			values = slices.DeleteFunc(values, func(v string) bool { return matches(v, value) })
+		}
+		return values, ldapResult{}
+
+	case modifyReplace:
+		return mod.values, ldapResult{}
+
+	case modifyIncrement:
+		if len(current) == 0 {
+			return nil, ldapResult{statusCode: ResultNoSuchAttribute, errorMessage: noSuchAttributeMessage(mod.name)}
+		}
+		if len(mod.values) != 1 || len(current) != 1 {
+			return nil, ldapResult{statusCode: ResultConstraintViolation, errorMessage: constraintViolationMessage(mod.name)}
+		}
+
+		value, err1 := strconv.ParseInt(current[0], 10, 64)
+		increment, err2 := strconv.ParseInt(mod.values[0], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, ldapResult{statusCode: ResultInvalidAttributeSyntax, errorMessage: invalidSyntaxMessage(mod.name)}
+		}
+		return []string{strconv.FormatInt(value+increment, 10)}, ldapResult{}
+	}
+
+	return nil, ldapResult{statusCode: ResultProtocolError, errorMessage: DecodingErrorMessage}
+}
+
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// Attributes of the objects in the configuration have single value. Values are
+// case-insensitive, like most of the attributes in AD.
+func modifySingleValue(current []string, mod modification, permissive bool) (string, ldapResult) {
+	values, result := modifyValues(current, mod, permissive, strings.EqualFold)
+	if result.statusCode != ResultSuccess {
+		return "", result
+	}
+	if len(values) > 1 {
+		return "", ldapResult{statusCode: ResultConstraintViolation, errorMessage: constraintViolationMessage(mod.name)}
+	}
+	if len(values) == 0 {
+		return "", ldapResult{}
+	}
+	return values[0], ldapResult{}
+}
+
+// Modifies attribute which has no special meaning, empty value removes the attribute
+func modifyPlainAttribute(attributes map[string]string, mod modification, permissive bool) ldapResult {
+	existing := mod.name
+	var current []string
+	for name, value := range attributes {
+		if strings.EqualFold(name, mod.name) {
+			existing = name
+			current = nonEmpty(value)
+		}
+	}
+
+	value, result := modifySingleValue(current, mod, permissive)
+	if result.statusCode != ResultSuccess {
+		return result
+	}
+
+	// Known attributes are stored with the name schema has, others keep their name
+	delete(attributes, existing)
+	if value != "" {
+		attributes[canonicalAttributeName(existing)] = value
+	}
+	return ldapResult{}
+}
+
+// Naming and system attributes can't be modified
+func checkModifiable(mod modification) ldapResult {
+	name := strings.ToLower(mod.name)
+	switch {
+	case name == "cn" || name == "ou" || name == "name":
+		return ldapResult{statusCode: ResultNotAllowedOnRDN, errorMessage: notAllowedOnRDNMessage}
+	case name == "objectclass" || name == "distinguishedname" || slices.Contains(systemAttributes, name):
+		return ldapResult{statusCode: ResultUnwillingToPerform, errorMessage: willNotPerformMessage}
+	}
+	return ldapResult{}
+}
+
+// Password is replaced by administrators, users change it by deleting the old password and
+// adding the new one
+func modifyPassword(user *models.User, mod modification) ldapResult {
+	if len(mod.values) != 1 {
+		return ldapResult{statusCode: ResultConstraintViolation, errorMessage: constraintViolationMessage(mod.name)}
+	}
+
+	password, ok := decodeUnicodePwd(mod.values[0])
+	if !ok {
+		return ldapResult{statusCode: ResultConstraintViolation, errorMessage: constraintViolationMessage(mod.name)}
+	}
+
+	switch mod.operation {
+	case modifyDelete:
+		if password != user.Password {
+			return ldapResult{statusCode: ResultConstraintViolation, errorMessage: constraintViolationMessage(mod.name)}
+		}
+	case modifyAdd, modifyReplace:
+		if password == "" {
+			return ldapResult{statusCode: ResultConstraintViolation, errorMessage: constraintViolationMessage(mod.name)}
+		}
+		user.Password = password
+	default:
+		return ldapResult{statusCode: ResultUnwillingToPerform, errorMessage: willNotPerformMessage}
+	}
+	return ldapResult{}
+}
+
+// Applies modifications to a copy of the user, so that nothing is changed if any of them
+// fails. Directory must be locked for writing.
+func modifyUser(config *models.AppConfig, idx int, mods []modification, permissive bool) ldapResult {
+	user := config.Users[idx]
+	user.Attributes = maps.Clone(user.Attributes)
+	if user.Attributes == nil {
+		user.Attributes = make(map[string]string)
+	}
+
+	var result ldapResult
+	for _, mod := range mods {
+		switch strings.ToLower(mod.name) {
+		case "unicodepwd":
+			result = modifyPassword(&user, mod)
+
+		case "userprincipalname":
+			var upn string
+			if upn, result = modifySingleValue(nonEmpty(user.Upn), mod, permissive); result.statusCode != ResultSuccess {
+				break
+			}
+			if upn != "" && !strings.EqualFold(upn, user.Upn) && slices.ContainsFunc(config.Users, func(c models.User) bool { return strings.EqualFold(c.Upn, upn) }) {
+				result = ldapResult{statusCode: ResultConstraintViolation, errorMessage: constraintViolationMessage(mod.name)}
+				break
+			}
+			user.Upn = upn
+			result = modifyPlainAttribute(user.Attributes, modification{operation: modifyReplace, partialAttribute: partialAttribute{name: "userPrincipalName", values: []string{upn}}}, permissive)
+
+		case "useraccountcontrol":
+			// Account flags can be changed, but not removed
+			var value string
+			if value, result = modifySingleValue([]string{strconv.Itoa(user.UserAccountControl)}, mod, permissive); result.statusCode != ResultSuccess {
+				break
+			}
+			uac, err := strconv.Atoi(value)
+			if err != nil {
+				result = ldapResult{statusCode: ResultInvalidAttributeSyntax, errorMessage: invalidSyntaxMessage(mod.name)}
+				break
+			}
+			applyUserAccountControl(&user, uac)
+
+		default:
+			result = modifyPlainAttribute(user.Attributes, mod, permissive)
+		}
+
+		if result.statusCode != ResultSuccess {
+			return result
+		}
+	}
+
+	config.Users[idx] = user
+	markChanged(config, &config.Users[idx].USN, modifiedAttributes(mods)...)
+	notifyChange(config, userElement(config, config.Users[idx]))
+	return ldapResult{}
+}
+
+// Group members are kept in the groups of the users, so they are changed only after all
+// modifications have succeeded. Directory must be locked for writing.
+func modifyGroup(config *models.AppConfig, idx int, mods []modification, permissive bool) ldapResult {
+	group := config.Groups[idx]
+	group.Attributes = maps.Clone(group.Attributes)
+	if group.Attributes == nil {
+		group.Attributes = make(map[string]string)
+	}
+
+	var members []string
+	for _, user := range config.Users {
+		if slices.Contains(user.Groups, group.Cn) {
+			members = append(members, userDN(user, config.Configuration.Domain))
+		}
+	}
+
+	var result ldapResult
+	for _, mod := range mods {
+		if strings.EqualFold(mod.name, "member") {
+			if mod.operation == modifyIncrement {
+				result = ldapResult{statusCode: ResultConstraintViolation, errorMessage: constraintViolationMessage(mod.name)}
+			} else {
+				members, result = modifyValues(members, mod, permissive, func(a, b string) bool { return normalizeDN(a) == normalizeDN(b) })
+			}
+		} else {
+			result = modifyPlainAttribute(group.Attributes, mod, permissive)
+		}
+
+		if result.statusCode != ResultSuccess {
+			return result
+		}
+	}
+
+	// Only users can be members
+	memberIdxs := make([]int, 0, len(members))
+	for _, member := range members {
+		userIdx := findUserIndex(config, "dn:"+member)
+		if userIdx < 0 {
+			return noSuchObjectResult(config, member)
+		}
+		memberIdxs = append(memberIdxs, userIdx)
+	}
+
+	for userIdx := range config.Users {
+		isMember := slices.Contains(memberIdxs, userIdx)
+		wasMember := slices.Contains(config.Users[userIdx].Groups, group.Cn)
+		if isMember && !wasMember {
+			config.Users[userIdx].Groups = append(config.Users[userIdx].Groups, group.Cn)
+		} else if !isMember && wasMember {
+			config.Users[userIdx].Groups = slices.DeleteFunc(config.Users[userIdx].Groups, func(g string) bool { return g == group.Cn })
+		}
+	}
+
+	config.Groups[idx] = group
+	markChanged(config, &config.Groups[idx].USN, modifiedAttributes(mods)...)
+	notifyChange(config, groupElement(config, config.Groups[idx]))
+	return ldapResult{}
+}
+
+// Directory must be locked for writing
+func modifyOrganizationalUnit(config *models.AppConfig, idx int, mods []modification, permissive bool) ldapResult {
+	ou := config.OrganizationalUnits[idx]
+	ou.Attributes = maps.Clone(ou.Attributes)
+	if ou.Attributes == nil {
+		ou.Attributes = make(map[string]string)
+	}
+
+	for _, mod := range mods {
+		if result := modifyPlainAttribute(ou.Attributes, mod, permissive); result.statusCode != ResultSuccess {
+			return result
+		}
+	}
+
+	config.OrganizationalUnits[idx] = ou
+	markChanged(config, &config.OrganizationalUnits[idx].USN, modifiedAttributes(mods)...)
+	notifyChange(config, ouElement(config, config.OrganizationalUnits[idx]))
+	return ldapResult{}
+}
+
+func modifiedAttributes(mods []modification) []string {
+	var names []string
+	for _, mod := range mods {
+		names = append(names, mod.name)
+	}
+	return names
+}
+
+func modifyObject(config *models.AppConfig, dn string, mods []modification, permissive bool) ldapResult {
+	for _, mod := range mods {
+		if result := checkModifiable(mod); result.statusCode != ResultSuccess {
+			return result
+		}
+	}
+
+	config.Lock()
+	defer config.Unlock()
+
+	if idx := findUserIndex(config, "dn:"+dn); idx >= 0 {
+		return modifyUser(config, idx, mods, permissive)
+	}
+	if idx := findGroupIndex(config, dn); idx >= 0 {
+		return modifyGroup(config, idx, mods, permissive)
+	}
+	if idx := findOUIndex(config, dn); idx >= 0 {
+		return modifyOrganizationalUnit(config, idx, mods, permissive)
+	}
+
+	return noSuchObjectResult(config, dn)
+}
+
+// Changes attributes of an object (RFC 4511, section 4.6). Either all modifications are
+// applied or none of them.
+func HandleModifyRequest(sess *Session, p *ber.Packet, op *Operation, config *models.AppConfig) {
+	if len(p.Children) != 2 {
+		sendResult(sess, op.ID, 0x07, ldapResult{statusCode: ResultProtocolError, errorMessage: DecodingErrorMessage})
+		return
+	}
+
+	if !sess.BindSuccessful {
+		sendResult(sess, op.ID, 0x07, ldapResult{statusCode: ResultInsufficientAccessRights, errorMessage: insufficientAccessMessage})
+		return
+	}
+
+	dn := p.Children[0].Data.String()
+	var mods []modification
+	for _, change := range p.Children[1].Children {
+		if len(change.Children) != 2 {
+			sendResult(sess, op.ID, 0x07, ldapResult{statusCode: ResultProtocolError, errorMessage: DecodingErrorMessage})
+			return
+		}
+
+		operation, ok1 := change.Children[0].Value.(int64)
+		attribute, ok2 := parsePartialAttribute(change.Children[1])
+		if !ok1 || !ok2 || operation < modifyAdd || operation > modifyIncrement {
+			sendResult(sess, op.ID, 0x07, ldapResult{statusCode: ResultProtocolError, errorMessage: DecodingErrorMessage})
+			return
+		}
+		mods = append(mods, modification{operation: operation, partialAttribute: attribute})
+	}
+
+	permissive := findControl(op.Controls, permissiveModifyOID) != nil
+
+	// Once the directory is changed, it's too late to stop
+	if err := op.finish(); err != nil {
+		sendStoppedResult(sess, op.ID, 0x07, err)
+		return
+	}
+
+	sendResult(sess, op.ID, 0x07, modifyObject(config, dn, mods, permissive))
+}
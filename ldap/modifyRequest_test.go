@@ -0,0 +1,216 @@
+package ldap
+
+import (
+	"slices"
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to create modify request packet
+func createModifyRequestPacket(dn string, mods ...modification) *ber.Packet {
+	modifyReq := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 0x06, nil, "")
+	modifyReq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+
+	changes := ber.NewSequence("")
+	for _, mod := range mods {
+		change := ber.NewSequence("")
+		change.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, mod.operation, ""))
+
+		attribute := ber.NewSequence("")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, mod.name, ""))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, value := range mod.values {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		}
+		attribute.AppendChild(values)
+		change.AppendChild(attribute)
+		changes.AppendChild(change)
+	}
+	modifyReq.AppendChild(changes)
+	return modifyReq
+}
+
+func newModification(operation int64, name string, values ...string) modification {
+	return modification{operation: operation, partialAttribute: partialAttribute{name: name, values: values}}
+}
+
+// Helper function to run modify request, returns result code
+func modifyEntry(t *testing.T, config *models.AppConfig, controls []Control, dn string, mods ...modification) int64 {
+	conn := mocks.NewMockConn()
+	op := NewOperation(1, 6)
+	op.Controls = controls
+	HandleModifyRequest(createTestSession(conn, true), createModifyRequestPacket(dn, mods...), op, config)
+	return lastResultCode(t, conn)
+}
+
+func createModifyTestConfig() *models.AppConfig {
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("user1", "user1@example.com", "pass", []string{"group1"}, map[string]string{"givenName": "One", "logonCount": "5"}),
+		createTestUser("user2", "user2@example.com", "pass", nil, nil),
+	}, []models.Group{createTestGroup("group1")})
+	config.Users[0].UserAccountControl = 512
+	config.Users[1].UserAccountControl = 512
+	return config
+}
+
+const user1DN = "CN=user1,CN=Users,DC=example,DC=com"
+
+func TestModifyAttributes(t *testing.T) {
+	config := createModifyTestConfig()
+
+	code := modifyEntry(t, config, nil, user1DN,
+		newModification(modifyReplace, "givenName", "First"),
+		newModification(modifyAdd, "sn", "User"),
+		newModification(modifyIncrement, "logonCount", "2"),
+	)
+	if code != ResultSuccess {
+		t.Fatalf("modify returned %d", code)
+	}
+
+	attributes := config.Users[0].Attributes
+	if attributes["givenName"] != "First" || attributes["sn"] != "User" || attributes["logonCount"] != "7" {
+		t.Errorf("attributes were not modified: %v", attributes)
+	}
+	if config.Users[0].AttributeUSNs["givenname"] == 0 {
+		t.Error("modified attribute should have update sequence number")
+	}
+
+	if code := modifyEntry(t, config, nil, user1DN, newModification(modifyDelete, "sn")); code != ResultSuccess {
+		t.Fatalf("deleting attribute returned %d", code)
+	}
+	if _, ok := config.Users[0].Attributes["sn"]; ok {
+		t.Error("deleted attribute should be removed")
+	}
+}
+
+func TestModifyLowercaseAttributeName(t *testing.T) {
+	config := createModifyTestConfig()
+
+	if code := modifyEntry(t, config, nil, user1DN, newModification(modifyReplace, "samaccountname", "jdoe"), newModification(modifyReplace, "GIVENNAME", "John")); code != ResultSuccess {
+		t.Fatalf("modify returned %d", code)
+	}
+	if attributes := config.Users[0].Attributes; attributes["sAMAccountName"] != "jdoe" || attributes["givenName"] != "John" || len(attributes) != 3 {
+		t.Errorf("attributes should be stored with schema names, got %v", attributes)
+	}
+}
+
+func TestModifyUserAccountControl(t *testing.T) {
+	config := createModifyTestConfig()
+
+	if code := modifyEntry(t, config, nil, user1DN, newModification(modifyReplace, "userAccountControl", "66050")); code != ResultSuccess {
+		t.Fatalf("modify returned %d", code)
+	}
+	if !config.Users[0].Disabled || !config.Users[0].PasswordNeverExpire || config.Users[0].UserAccountControl != 66050 {
+		t.Errorf("account flags should follow userAccountControl: %+v", config.Users[0])
+	}
+
+	if code := modifyEntry(t, config, nil, user1DN, newModification(modifyReplace, "userAccountControl", "512")); code != ResultSuccess {
+		t.Fatalf("modify returned %d", code)
+	}
+	if config.Users[0].Disabled || config.Users[0].PasswordNeverExpire {
+		t.Errorf("account should be enabled again: %+v", config.Users[0])
+	}
+}
+
+func TestModifyGroupMembers(t *testing.T) {
+	config := createModifyTestConfig()
+	groupDN := "CN=group1,CN=Users,DC=example,DC=com"
+
+	if code := modifyEntry(t, config, nil, groupDN,
+		newModification(modifyAdd, "member", "cn=user2,cn=users,dc=example,dc=com"),
+		newModification(modifyDelete, "member", user1DN),
+	); code != ResultSuccess {
+		t.Fatalf("modify returned %d", code)
+	}
+	if len(config.Users[0].Groups) != 0 || !slices.Equal(config.Users[1].Groups, []string{"group1"}) {
+		t.Errorf("group members were not changed: %v, %v", config.Users[0].Groups, config.Users[1].Groups)
+	}
+
+	// Existing member can't be added again, unless modify is permissive
+	if code := modifyEntry(t, config, nil, groupDN, newModification(modifyAdd, "member", "CN=user2,CN=Users,DC=example,DC=com")); code != ResultAttributeOrValueExists {
+		t.Errorf("adding existing member returned %d, want attributeOrValueExists", code)
+	}
+	if code := modifyEntry(t, config, nil, groupDN, newModification(modifyDelete, "member", user1DN)); code != ResultNoSuchAttribute {
+		t.Errorf("deleting missing member returned %d, want noSuchAttribute", code)
+	}
+
+	permissive := []Control{{Type: permissiveModifyOID}}
+	if code := modifyEntry(t, config, permissive, groupDN,
+		newModification(modifyAdd, "member", "CN=user2,CN=Users,DC=example,DC=com"),
+		newModification(modifyDelete, "member", user1DN),
+	); code != ResultSuccess {
+		t.Errorf("permissive modify returned %d", code)
+	}
+
+	if code := modifyEntry(t, config, nil, groupDN, newModification(modifyReplace, "member", user1DN)); code != ResultSuccess {
+		t.Fatalf("replacing members returned %d", code)
+	}
+	if !slices.Equal(config.Users[0].Groups, []string{"group1"}) || len(config.Users[1].Groups) != 0 {
+		t.Errorf("members should be replaced: %v, %v", config.Users[0].Groups, config.Users[1].Groups)
+	}
+}
+
+func TestModifyPassword(t *testing.T) {
+	config := createModifyTestConfig()
+
+	// Change with old password
+	if code := modifyEntry(t, config, nil, user1DN,
+		newModification(modifyDelete, "unicodePwd", encodeUnicodePwd("wrong")),
+		newModification(modifyAdd, "unicodePwd", encodeUnicodePwd("new")),
+	); code != ResultConstraintViolation {
+		t.Errorf("change with wrong old password returned %d", code)
+	}
+	if code := modifyEntry(t, config, nil, user1DN,
+		newModification(modifyDelete, "unicodePwd", encodeUnicodePwd("pass")),
+		newModification(modifyAdd, "unicodePwd", encodeUnicodePwd("new")),
+	); code != ResultSuccess || config.Users[0].Password != "new" {
+		t.Errorf("password change returned %d", code)
+	}
+
+	// Reset
+	if code := modifyEntry(t, config, nil, user1DN, newModification(modifyReplace, "unicodePwd", encodeUnicodePwd("reset"))); code != ResultSuccess || config.Users[0].Password != "reset" {
+		t.Errorf("password reset returned %d", code)
+	}
+}
+
+func TestModifyErrors(t *testing.T) {
+	config := createModifyTestConfig()
+
+	tests := []struct {
+		name     string
+		dn       string
+		mods     []modification
+		expected int64
+	}{
+		{"missing object", "CN=nobody,CN=Users,DC=example,DC=com", []modification{newModification(modifyReplace, "sn", "x")}, ResultNoSuchObject},
+		{"naming attribute", user1DN, []modification{newModification(modifyReplace, "cn", "x")}, ResultNotAllowedOnRDN},
+		{"system attribute", user1DN, []modification{newModification(modifyAdd, "memberOf", "CN=group1,CN=Users,DC=example,DC=com")}, ResultUnwillingToPerform},
+		{"missing attribute", user1DN, []modification{newModification(modifyDelete, "sn")}, ResultNoSuchAttribute},
+		{"second value", user1DN, []modification{newModification(modifyAdd, "givenName", "Two")}, ResultConstraintViolation},
+		{"increment non-integer", user1DN, []modification{newModification(modifyIncrement, "givenName", "1")}, ResultInvalidAttributeSyntax},
+		{"duplicate upn", user1DN, []modification{newModification(modifyReplace, "userPrincipalName", "user2@example.com")}, ResultConstraintViolation},
+		{"missing member", "CN=group1,CN=Users,DC=example,DC=com", []modification{newModification(modifyAdd, "member", "CN=nobody,CN=Users,DC=example,DC=com")}, ResultNoSuchObject},
+		// Nothing is changed when any modification fails
+		{"partial failure", user1DN, []modification{newModification(modifyReplace, "givenName", "Changed"), newModification(modifyDelete, "sn")}, ResultNoSuchAttribute},
+	}
+
+	for _, tt := range tests {
+		if code := modifyEntry(t, config, nil, tt.dn, tt.mods...); code != tt.expected {
+			t.Errorf("%s: modify returned %d, want %d", tt.name, code, tt.expected)
+		}
+	}
+
+	if config.Users[0].Attributes["givenName"] != "One" {
+		t.Errorf("failed modify should not change attributes, got %v", config.Users[0].Attributes)
+	}
+
+	conn := mocks.NewMockConn()
+	HandleModifyRequest(createTestSession(conn, false), createModifyRequestPacket(user1DN, newModification(modifyReplace, "sn", "x")), NewOperation(1, 6), config)
+	if code := lastResultCode(t, conn); code != ResultInsufficientAccessRights {
+		t.Errorf("modify without bind returned %d, want insufficientAccessRights", code)
+	}
+}
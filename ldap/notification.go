@@ -0,0 +1,118 @@
+package ldap
+
+import (
+	"smad/models"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+const notificationOID = "1.2.840.113556.1.4.528"
+
+// Same limit as AD's MaxNotificationPerConn
+const maxNotificationsPerConn = 5
+
+// Search which waits for changes in the directory (AD's LDAP_SERVER_NOTIFICATION_OID control)
+type changeListener struct {
+	sess   *Session
+	config *models.AppConfig
+	baseDN string
+	scope  int64
+	filter *ber.Packet
+
+	lock    sync.Mutex
+	pending []models.LdapElement
+	signal  chan struct{}
+}
+
+var (
+	listenersLock sync.Mutex
+	listeners     = make(map[*changeListener]struct{})
+)
+
+func addChangeListener(sess *Session, config *models.AppConfig, baseDN string, scope int64, filter *ber.Packet) *changeListener {
+	listener := &changeListener{sess: sess, config: config, baseDN: baseDN, scope: scope, filter: filter, signal: make(chan struct{}, 1)}
+
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+
+	count := 0
+	for other := range listeners {
+		if other.sess == sess {
+			count++
+		}
+	}
+	if count >= maxNotificationsPerConn {
+		return nil
+	}
+
+	listeners[listener] = struct{}{}
+	return listener
+}
+
+func removeChangeListener(listener *changeListener) {
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+	delete(listeners, listener)
+}
+
+// Tells searches waiting for changes, that object was added, modified or deleted. Never blocks,
+// so it's safe to call while directory is locked.
+func notifyChange(config *models.AppConfig, object models.LdapElement) {
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+
+	for listener := range listeners {
+		if listener.config != config {
+			continue
+		}
+		// Deleted object is under CN=Deleted Objects, but it's in scope where it was before
+		changed := objectsInScope([]models.LdapElement{object}, listener.baseDN, listener.scope)
+		if object.Attributes["isDeleted"] == "TRUE" {
+			changed = tombstonesInScope([]models.LdapElement{object}, listener.baseDN, listener.scope)
+		}
+		if len(filterObjects(changed, listener.filter)) == 0 {
+			continue
+		}
+
+		listener.lock.Lock()
+		listener.pending = append(listener.pending, object)
+		listener.lock.Unlock()
+
+		select {
+		case listener.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Returns changes which haven't been sent to the client yet
+func (l *changeListener) takePending() []models.LdapElement {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	pending := l.pending
+	l.pending = nil
+	return pending
+}
+
+// Sends changed objects to the client until search is abandoned or canceled. Nothing is sent
+// for the objects which already exist, like in AD.
+func waitForChanges(sess *Session, op *Operation, listener *changeListener, options entryOptions) {
+	defer removeChangeListener(listener)
+
+	for {
+		select {
+		case <-op.ctx.Done():
+			return
+		case <-listener.signal:
+		}
+
+		for _, object := range listener.takePending() {
+			if op.stopped() {
+				return
+			}
+			sess.Write(createSearchResEntryMessage(op.ID, object, options).Bytes())
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package ldap
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to start change notification search, returns once it's waiting for changes
+func startNotificationSearch(t *testing.T, sess *Session, msgNum int64, config *models.AppConfig) *Operation {
+	return startNotificationRequest(t, sess, msgNum, config, createSearchRequestPacket("DC=example,DC=com", "(objectClass=person)"))
+}
+
+func startNotificationRequest(t *testing.T, sess *Session, msgNum int64, config *models.AppConfig, p *ber.Packet) *Operation {
+	listenerCount := countChangeListeners(sess)
+
+	op := NewOperation(msgNum, 3)
+	op.Controls = []Control{{Type: notificationOID, Criticality: true}}
+	sess.Run(op, func() {
+		HandleSearchRequest(sess, p, op, config)
+	})
+
+	for i := 0; i < 100; i++ {
+		if countChangeListeners(sess) > listenerCount {
+			return op
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("notification search didn't start")
+	return nil
+}
+
+// Amount of data written to connection, while search might still be writing to it
+func writtenLength(sess *Session, conn *mocks.MockConn) int {
+	sess.writeLock.Lock()
+	defer sess.writeLock.Unlock()
+	return len(conn.GetWrittenData())
+}
+
+func countChangeListeners(sess *Session) int {
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+
+	count := 0
+	for listener := range listeners {
+		if listener.sess == sess {
+			count++
+		}
+	}
+	return count
+}
+
+func TestNotificationSearch(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := createTestSession(conn, true)
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("testuser", "testuser@example.com", "testpass", nil, map[string]string{}),
+	}, []models.Group{createTestGroup("testgroup")})
+
+	startNotificationSearch(t, sess, 3, config)
+
+	// Existing objects are not sent, only changes which match the filter
+	notifyChange(config, groupElement(config, config.Groups[0]))
+	notifyChange(config, userElement(config, config.Users[0]))
+	notifyChange(createTestConfig("example.com"), userElement(config, config.Users[0]))
+
+	for i := 0; i < 100 && writtenLength(sess, conn) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Abandoned search ends without response
+	HandleAbandonRequest(sess, ber.NewInteger(ber.ClassApplication, ber.TypePrimitive, 16, 3, ""))
+	sess.Wait()
+
+	messages := decodeMessages(t, conn)
+	if len(messages) != 1 {
+		t.Fatalf("notification search wrote %d messages, want 1", len(messages))
+	}
+	if messages[0].Children[1].Tag != 0x04 || messages[0].Children[1].Children[0].Value != "CN=testuser,CN=Users,DC=example,DC=com" {
+		t.Error("notification search should send entry of changed user")
+	}
+	if countChangeListeners(sess) != 0 {
+		t.Error("abandoned notification search should stop listening for changes")
+	}
+}
+
+func TestNotificationSearchScope(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := createTestSession(conn, true)
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("testuser", "testuser@example.com", "testpass", nil, map[string]string{}),
+		createTestUser("staffuser", "staffuser@example.com", "testpass", nil, map[string]string{}),
+	}, nil)
+	config.OrganizationalUnits = []models.OrganizationalUnit{{Ou: "Staff"}}
+	config.Users[1].Container = "OU=Staff"
+
+	// Base object search of the OU doesn't see changes to users under it
+	baseSearch := createSearchRequestPacket("OU=Staff,DC=example,DC=com", "")
+	baseSearch.Children[1] = ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, scopeBaseObject, "")
+	startNotificationRequest(t, sess, 3, config, baseSearch)
+
+	levelSearch := createSearchRequestPacket("OU=Staff,DC=example,DC=com", "(objectClass=person)")
+	levelSearch.Children[1] = ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, scopeSingleLevel, "")
+	startNotificationRequest(t, sess, 4, config, levelSearch)
+
+	notifyChange(config, userElement(config, config.Users[0]))
+	notifyChange(config, userElement(config, config.Users[1]))
+
+	for i := 0; i < 100 && writtenLength(sess, conn) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	HandleAbandonRequest(sess, ber.NewInteger(ber.ClassApplication, ber.TypePrimitive, 16, 3, ""))
+	HandleAbandonRequest(sess, ber.NewInteger(ber.ClassApplication, ber.TypePrimitive, 16, 4, ""))
+	sess.Wait()
+
+	messages := decodeMessages(t, conn)
+	if len(messages) != 1 {
+		t.Fatalf("notification searches wrote %d messages, want 1", len(messages))
+	}
+	if messages[0].Children[0].Value != int64(4) || messages[0].Children[1].Children[0].Value != "CN=staffuser,OU=Staff,DC=example,DC=com" {
+		t.Error("one level notification search should only send entry of user under the OU")
+	}
+}
+
+func TestNotificationSearchDeletedObject(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := createTestSession(conn, true)
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("staffuser", "staffuser@example.com", "testpass", nil, map[string]string{}),
+	}, nil)
+	config.OrganizationalUnits = []models.OrganizationalUnit{{Ou: "Staff"}}
+	config.Users[0].Container = "OU=Staff"
+	config.Users[0].ObjectGUID = "0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f"
+
+	startNotificationRequest(t, sess, 3, config, createSearchRequestPacket("OU=Staff,DC=example,DC=com", "(objectClass=person)"))
+
+	if code := deleteEntry(t, config, true, "CN=staffuser,OU=Staff,DC=example,DC=com"); code != ResultSuccess {
+		t.Fatalf("delete returned %d", code)
+	}
+
+	for i := 0; i < 100 && writtenLength(sess, conn) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	HandleAbandonRequest(sess, ber.NewInteger(ber.ClassApplication, ber.TypePrimitive, 16, 3, ""))
+	sess.Wait()
+
+	messages := decodeMessages(t, conn)
+	if len(messages) != 1 || messages[0].Children[1].Children[0].Value != "CN=staffuser\\0ADEL:0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f,CN=Deleted Objects,DC=example,DC=com" {
+		t.Errorf("notification search of OU should send deleted user, got %d messages", len(messages))
+	}
+}
+
+func TestNotificationSearchCancel(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := createTestSession(conn, true)
+	config := createTestConfig("example.com")
+
+	startNotificationSearch(t, sess, 3, config)
+	HandleExtendedRequest(sess, createCancelRequestPacket(3), 4, config)
+	sess.Wait()
+
+	messages := decodeMessages(t, conn)
+	if len(messages) != 2 || messages[0].Children[1].Children[0].Value != int64(ResultCanceled) || lastResultCode(t, conn) != ResultSuccess {
+		t.Error("canceled notification search should respond with canceled result")
+	}
+}
+
+func TestNotificationSearchLimit(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := createTestSession(conn, true)
+	config := createTestConfig("example.com")
+
+	for i := 1; i <= maxNotificationsPerConn; i++ {
+		startNotificationSearch(t, sess, int64(i), config)
+	}
+
+	op := NewOperation(10, 3)
+	op.Controls = []Control{{Type: notificationOID, Criticality: true}}
+	HandleSearchRequest(sess, createSearchRequestPacket("DC=example,DC=com", ""), op, config)
+
+	if lastResultCode(t, conn) != ResultAdminLimitExceeded {
+		t.Error("too many notification searches should fail with adminLimitExceeded")
+	}
+
+	// Bind doesn't wait for notification searches, they are abandoned instead
+	sess.WaitForBind()
+	if countChangeListeners(sess) != 0 {
+		t.Error("bind should abandon notification searches")
+	}
+}
+
+func TestNotificationSearchOutlivesIdleTime(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	sess := NewSession(serverConn)
+	sess.BindSuccessful = true
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("testuser", "testuser@example.com", "testpass", nil, map[string]string{}),
+	}, nil)
+
+	// Without outstanding operations, idle connection times out
+	if err := sess.WaitForMessage(20 * time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("idle connection should time out, got %v", err)
+	}
+
+	op := startNotificationSearch(t, sess, 3, config)
+
+	done := make(chan error)
+	go func() {
+		done <- sess.WaitForMessage(20 * time.Millisecond)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("connection with notification search shouldn't time out, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Client sending something ends the wait
+	go clientConn.Write([]byte{0x30})
+	if err := <-done; err != nil {
+		t.Errorf("waiting for message failed: %v", err)
+	}
+
+	op.stop(errAbandoned)
+	sess.Wait()
+}
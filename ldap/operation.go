@@ -0,0 +1,68 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+var (
+	errAbandoned = errors.New("operation abandoned")
+	errCanceled  = errors.New("operation canceled")
+)
+
+// Operation is an outstanding request of a session. Long running operations check whether
+// they have been abandoned or canceled, and stop processing if so.
+type Operation struct {
+	ID       int64
+	Tag      ber.Tag
+	Controls []Control
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+
+	lock     sync.Mutex
+	finished bool
+	aborted  bool
+}
+
+func NewOperation(msgNum int64, tag ber.Tag) *Operation {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	return &Operation{ID: msgNum, Tag: tag, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+// Asks operation to stop. Returns false if it's too late, because operation has already
+// started sending its final response.
+func (o *Operation) stop(cause error) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.finished {
+		return false
+	}
+
+	o.cancel(cause)
+	return true
+}
+
+// Has someone asked the operation to stop?
+func (o *Operation) stopped() bool {
+	return o.ctx.Err() != nil
+}
+
+// Marks that operation is about to send its final response, after which it can no longer be
+// stopped. Returns the reason if operation was stopped before that.
+func (o *Operation) finish() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.finished = true
+	if o.ctx.Err() != nil {
+		o.aborted = true
+		return context.Cause(o.ctx)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package ldap
+
+import (
+	"crypto/rand"
+	"errors"
+	"slices"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+const pagedResultsOID = "1.2.840.113556.1.4.319"
+
+// Same limit as AD's MaxResultSetsPerConn, oldest paged search is dropped when exceeded
+const maxPagedSearches = 10
+
+// Paged search which client can continue with the cookie it received
+type pagedSearch struct {
+	cookie  string
+	request string               // encoded search request, next pages must repeat the same search
+	msgNum  int64                // message id of the latest page, so that it can be abandoned
+	results []models.LdapElement // entries which haven't been returned yet
+	limited bool                 // results were cut to client's size limit
+}
+
+type pagedResultsControl struct {
+	size   int
+	cookie string
+}
+
+// Parses value of simple paged results control (RFC 2696)
+func parsePagedResultsControl(value []byte) (pagedResultsControl, error) {
+	var control pagedResultsControl
+
+	valuePacket, err := ber.DecodePacketErr(value)
+	if err != nil {
+		return control, err
+	}
+
+	if len(valuePacket.Children) != 2 || valuePacket.Children[0].Tag != ber.TagInteger || valuePacket.Children[1].Tag != ber.TagOctetString {
+		return control, errors.New("invalid paged results control")
+	}
+
+	size, ok := valuePacket.Children[0].Value.(int64)
+	if !ok || size < 0 {
+		return control, errors.New("invalid page size")
+	}
+
+	control.size = int(size)
+	control.cookie = valuePacket.Children[1].Data.String()
+	return control, nil
+}
+
+// Response control tells the cookie for the next page. AD doesn't estimate the result set
+// size, so it's always 0 like there.
+func createPagedResultsControl(cookie string) Control {
+	value := ber.NewSequence("")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 0, ""))
+	value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, cookie, ""))
+	return Control{Type: pagedResultsOID, Value: value.Bytes()}
+}
+
+func generateCookie() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return string(buf)
+}
+
+// Stores rest of the results of paged search, returns cookie for continuing it. Oldest searches
+// are forgotten, when session has too many of them or they have more than maxResultSetSize
+// entries together (0 = no limit), like AD does.
+func (s *Session) storePagedSearch(search *pagedSearch, maxResultSetSize int) string {
+	s.pagingLock.Lock()
+	defer s.pagingLock.Unlock()
+
+	if len(s.pagedSearches) >= maxPagedSearches {
+		s.pagedSearches = s.pagedSearches[1:]
+	}
+
+	if maxResultSetSize > 0 {
+		stored := len(search.results)
+		for _, previous := range s.pagedSearches {
+			stored += len(previous.results)
+		}
+		for len(s.pagedSearches) > 0 && stored > maxResultSetSize {
+			stored -= len(s.pagedSearches[0].results)
+			s.pagedSearches = s.pagedSearches[1:]
+		}
+	}
+
+	search.cookie = generateCookie()
+	s.pagedSearches = append(s.pagedSearches, search)
+	return search.cookie
+}
+
+// Removes paged search from the session and returns it, or nil if cookie is not valid.
+// Every cookie can be used only once, next page gets a new one.
+func (s *Session) takePagedSearch(cookie string) *pagedSearch {
+	s.pagingLock.Lock()
+	defer s.pagingLock.Unlock()
+
+	for idx, search := range s.pagedSearches {
+		if search.cookie == cookie {
+			s.pagedSearches = slices.Delete(s.pagedSearches, idx, idx+1)
+			return search
+		}
+	}
+	return nil
+}
+
+// Forgets paged search, whose latest page was returned by given message
+func (s *Session) dropPagedSearch(msgNum int64) {
+	s.pagingLock.Lock()
+	defer s.pagingLock.Unlock()
+
+	for idx, search := range s.pagedSearches {
+		if search.msgNum == msgNum {
+			s.pagedSearches = slices.Delete(s.pagedSearches, idx, idx+1)
+			return
+		}
+	}
+}
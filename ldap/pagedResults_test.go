@@ -0,0 +1,170 @@
+package ldap
+
+import (
+	"fmt"
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to create paged results request control
+func createPagedResultsRequest(size int64, cookie string) Control {
+	value := ber.NewSequence("")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, size, ""))
+	value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, cookie, ""))
+	return Control{Type: pagedResultsOID, Value: value.Bytes()}
+}
+
+// Helper function to create configuration with given number of users
+func createPagingTestConfig(userCount int) *models.AppConfig {
+	var users []models.User
+	for i := 0; i < userCount; i++ {
+		users = append(users, createTestUser(fmt.Sprintf("user%d", i), fmt.Sprintf("user%d@example.com", i), "pass", nil, map[string]string{}))
+	}
+	return createTestConfigWithUsersAndGroups("example.com", users, nil)
+}
+
+// Helper function to run one page of paged search. Returns number of entries, result code and
+// cookie of the response.
+func searchPage(t *testing.T, sess *Session, conn *mocks.MockConn, searchReq *ber.Packet, msgNum int64, size int64, cookie string, config *models.AppConfig) (int, int64, string) {
+	conn.Reset()
+
+	op := NewOperation(msgNum, 3)
+	op.Controls = []Control{createPagedResultsRequest(size, cookie)}
+	HandleSearchRequest(sess, searchReq, op, config)
+
+	messages := decodeMessages(t, conn)
+	if len(messages) == 0 {
+		t.Fatal("no response written")
+	}
+
+	done := messages[len(messages)-1]
+	resultCode := done.Children[1].Children[0].Value.(int64)
+
+	responseCookie := ""
+	if controls, _ := parseControls(done); len(controls) == 1 && controls[0].Type == pagedResultsOID {
+		control, err := parsePagedResultsControl(controls[0].Value)
+		if err != nil {
+			t.Fatalf("invalid paged results response control: %v", err)
+		}
+		responseCookie = control.cookie
+	}
+
+	return len(messages) - 1, resultCode, responseCookie
+}
+
+func TestPagedSearch(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := createTestSession(conn, true)
+	config := createPagingTestConfig(5)
+	searchReq := createSearchRequestPacket("DC=example,DC=com", "(objectClass=person)")
+
+	entries, resultCode, cookie := searchPage(t, sess, conn, searchReq, 1, 2, "", config)
+	if entries != 2 || resultCode != ResultSuccess || cookie == "" {
+		t.Fatalf("first page = %d entries, result %d, cookie %q, want 2 entries and cookie", entries, resultCode, cookie)
+	}
+
+	entries, _, cookie = searchPage(t, sess, conn, searchReq, 2, 2, cookie, config)
+	if entries != 2 || cookie == "" {
+		t.Fatalf("second page = %d entries, cookie %q, want 2 entries and cookie", entries, cookie)
+	}
+
+	entries, _, cookie = searchPage(t, sess, conn, searchReq, 3, 2, cookie, config)
+	if entries != 1 || cookie != "" {
+		t.Errorf("last page = %d entries, cookie %q, want 1 entry and empty cookie", entries, cookie)
+	}
+}
+
+func TestPagedSearchInvalidCookie(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := createTestSession(conn, true)
+	config := createPagingTestConfig(5)
+	searchReq := createSearchRequestPacket("DC=example,DC=com", "")
+
+	_, resultCode, _ := searchPage(t, sess, conn, searchReq, 1, 2, "bogus", config)
+	if resultCode != ResultUnwillingToPerform {
+		t.Errorf("unknown cookie should fail with unwillingToPerform, got %d", resultCode)
+	}
+
+	// Cookie can't be used for different search
+	_, _, cookie := searchPage(t, sess, conn, searchReq, 2, 2, "", config)
+	otherReq := createSearchRequestPacket("DC=example,DC=com", "(objectClass=person)")
+	_, resultCode, _ = searchPage(t, sess, conn, otherReq, 3, 2, cookie, config)
+	if resultCode != ResultUnwillingToPerform {
+		t.Errorf("cookie of another search should fail with unwillingToPerform, got %d", resultCode)
+	}
+}
+
+func TestPagedSearchSizeZeroEndsSearch(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := createTestSession(conn, true)
+	config := createPagingTestConfig(5)
+	searchReq := createSearchRequestPacket("DC=example,DC=com", "")
+
+	_, _, cookie := searchPage(t, sess, conn, searchReq, 1, 2, "", config)
+
+	entries, resultCode, newCookie := searchPage(t, sess, conn, searchReq, 2, 0, cookie, config)
+	if entries != 0 || resultCode != ResultSuccess || newCookie != "" {
+		t.Errorf("page size 0 = %d entries, result %d, cookie %q, want no entries and empty cookie", entries, resultCode, newCookie)
+	}
+
+	if _, resultCode, _ = searchPage(t, sess, conn, searchReq, 3, 2, cookie, config); resultCode != ResultUnwillingToPerform {
+		t.Errorf("cookie should be invalid after page size 0, got result %d", resultCode)
+	}
+}
+
+func TestPagedSearchAbandon(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := createTestSession(conn, true)
+	config := createPagingTestConfig(5)
+	searchReq := createSearchRequestPacket("DC=example,DC=com", "")
+
+	_, _, cookie := searchPage(t, sess, conn, searchReq, 1, 2, "", config)
+
+	// Abandoning the latest page ends the paged search
+	HandleAbandonRequest(sess, ber.NewInteger(ber.ClassApplication, ber.TypePrimitive, 0x10, 1, ""))
+
+	if _, resultCode, _ := searchPage(t, sess, conn, searchReq, 2, 2, cookie, config); resultCode != ResultUnwillingToPerform {
+		t.Errorf("cookie should be invalid after abandon, got result %d", resultCode)
+	}
+}
+
+func TestPagedSearchLimits(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := createTestSession(conn, true)
+	config := createPagingTestConfig(5)
+	config.Configuration.MaxPageSize = 2
+
+	// Page can't be larger than MaxPageSize, and client's size limit covers all pages
+	searchReq := createSearchRequestPacket("DC=example,DC=com", "(objectClass=person)")
+	searchReq.Children[3] = ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 3, "")
+
+	entries, resultCode, cookie := searchPage(t, sess, conn, searchReq, 1, 100, "", config)
+	if entries != 2 || resultCode != ResultSuccess || cookie == "" {
+		t.Fatalf("first page = %d entries, result %d, cookie %q, want 2 entries and cookie", entries, resultCode, cookie)
+	}
+
+	entries, resultCode, cookie = searchPage(t, sess, conn, searchReq, 2, 100, cookie, config)
+	if entries != 1 || resultCode != ResultSizeLimitExceeded || cookie != "" {
+		t.Errorf("last page = %d entries, result %d, cookie %q, want 1 entry and sizeLimitExceeded", entries, resultCode, cookie)
+	}
+}
+
+func TestStorePagedSearchResultSetSize(t *testing.T) {
+	sess := createTestSession(mocks.NewMockConn(), true)
+	results := make([]models.LdapElement, 3)
+
+	first := sess.storePagedSearch(&pagedSearch{results: results}, 5)
+	second := sess.storePagedSearch(&pagedSearch{results: results}, 5)
+
+	// Oldest search is forgotten, when stored results don't fit in the limit
+	if sess.takePagedSearch(first) != nil {
+		t.Error("oldest paged search should be forgotten")
+	}
+	if sess.takePagedSearch(second) == nil {
+		t.Error("latest paged search should be kept")
+	}
+}
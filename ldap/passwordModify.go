@@ -0,0 +1,98 @@
+package ldap
+
+import (
+	"crypto/rand"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+const passwordModifyOID = "1.3.6.1.4.1.4203.1.11.1"
+
+func generatePassword() string {
+	const chars = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	for idx, b := range buf {
+		buf[idx] = chars[int(b)%len(chars)]
+	}
+	return string(buf)
+}
+
+// Password modify operation (RFC 3062). Users can change their own password when they know
+// the old one, administrators can reset password of any user. If new password is not given,
+// server generates one and returns it to the client.
+func passwordModify(sess *Session, requestValue []byte, config *models.AppConfig) extendedResult {
+	if !sess.BindSuccessful {
+		return extendedResult{statusCode: ResultInsufficientAccessRights, errorMessage: insufficientAccessMessage}
+	}
+
+	var userIdentity string
+	var oldPassword, newPassword *string
+
+	// Request value and all its fields are optional
+	if len(requestValue) > 0 {
+		valuePacket, err := ber.DecodePacketErr(requestValue)
+		if err != nil {
+			return extendedResult{statusCode: ResultProtocolError, errorMessage: DecodingErrorMessage}
+		}
+
+		for _, child := range valuePacket.Children {
+			value := child.Data.String()
+			switch child.Tag {
+			case 0:
+				userIdentity = value
+			case 1:
+				oldPassword = &value
+			case 2:
+				newPassword = &value
+			}
+		}
+	}
+
+	config.Lock()
+	defer config.Unlock()
+
+	bindUserIdx := boundUserIndex(config, sess)
+	targetIdx := bindUserIdx
+	if userIdentity != "" {
+		targetIdx = findUserIndex(config, userIdentity)
+	}
+
+	if targetIdx < 0 {
+		return extendedResult{statusCode: ResultNoSuchObject, errorMessage: "0000208D: NameErr: DSID-0310028C, problem 2001 (NO_OBJECT), data 0, best match of:"}
+	}
+
+	isAdmin := bindUserIdx >= 0 && isAdministrator(config.Users[bindUserIdx])
+	if targetIdx != bindUserIdx && !isAdmin {
+		return extendedResult{statusCode: ResultInsufficientAccessRights, errorMessage: insufficientAccessMessage}
+	}
+
+	// Password change needs the old password, only administrators can reset it without
+	if (oldPassword == nil && !isAdmin) || (oldPassword != nil && *oldPassword != config.Users[targetIdx].Password) {
+		return extendedResult{statusCode: ResultConstraintViolation, errorMessage: "00000056: AtrErr: DSID-03191083, #1:\n\t0: 00000056: DSID-03191083, problem 1005 (CONSTRAINT_ATT_TYPE), data 0, Att 9005a (unicodePwd)\n"}
+	}
+
+	if newPassword != nil && *newPassword == "" {
+		return extendedResult{statusCode: ResultConstraintViolation, errorMessage: constraintViolationMessage("unicodePwd")}
+	}
+
+	rsp := extendedResult{statusCode: ResultSuccess}
+
+	if newPassword == nil {
+		// Response value contains the generated password
+		generated := generatePassword()
+		newPassword = &generated
+
+		value := ber.NewSequence("")
+		value.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, generated, ""))
+		rsp.responseValue = value.Bytes()
+	}
+
+	config.Users[targetIdx].Password = *newPassword
+	markChanged(config, &config.Users[targetIdx].USN, "unicodePwd")
+	notifyChange(config, userElement(config, config.Users[targetIdx]))
+
+	return rsp
+}
@@ -0,0 +1,175 @@
+package ldap
+
+import (
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to create password modify request, empty values are left out
+func createPasswordModifyPacket(userIdentity, oldPassword, newPassword string) *ber.Packet {
+	value := ber.NewSequence("")
+	if userIdentity != "" {
+		value.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, userIdentity, ""))
+	}
+	if oldPassword != "" {
+		value.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 1, oldPassword, ""))
+	}
+	if newPassword != "" {
+		value.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 2, newPassword, ""))
+	}
+	return createExtendedRequestPacket(passwordModifyOID, value)
+}
+
+// Helper function to create configuration with normal user and administrator
+func createPasswordTestConfig() *models.AppConfig {
+	return createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("Test User", "test.user@example.com", "oldpass", nil, map[string]string{}),
+		createTestUser("Admin", "admin@example.com", "adminpass", []string{"Domain Admins"}, map[string]string{}),
+	}, []models.Group{createTestGroup("Domain Admins")})
+}
+
+// Helper function to create session bound as given user
+func createBoundSession(conn *mocks.MockConn, config *models.AppConfig, upn, password string) *Session {
+	sess := NewSession(conn)
+	bindReq := createLDAPMessageWithBindRequest(upn, password, 1)
+	HandleBindRequest(sess, bindReq.Children[1], 1, config)
+	conn.Reset()
+	return sess
+}
+
+func TestPasswordModifyOwnPassword(t *testing.T) {
+	conn := mocks.NewMockConn()
+	config := createPasswordTestConfig()
+	sess := createBoundSession(conn, config, "test.user@example.com", "oldpass")
+
+	HandleExtendedRequest(sess, createPasswordModifyPacket("", "oldpass", "newpass"), 2, config)
+
+	if code := lastResultCode(t, conn); code != ResultSuccess {
+		t.Errorf("password change = %d, want success", code)
+	}
+	if config.Users[0].Password != "newpass" {
+		t.Error("password change should update user password")
+	}
+}
+
+func TestPasswordModifyWrongOldPassword(t *testing.T) {
+	conn := mocks.NewMockConn()
+	config := createPasswordTestConfig()
+	sess := createBoundSession(conn, config, "test.user@example.com", "oldpass")
+
+	HandleExtendedRequest(sess, createPasswordModifyPacket("", "wrongpass", "newpass"), 2, config)
+
+	if code := lastResultCode(t, conn); code != ResultConstraintViolation {
+		t.Errorf("password change with wrong old password = %d, want constraintViolation", code)
+	}
+	if config.Users[0].Password != "oldpass" {
+		t.Error("failed password change should not update user password")
+	}
+}
+
+func TestPasswordModifyEmptyNewPassword(t *testing.T) {
+	config := createPasswordTestConfig()
+	sess := createBoundSession(mocks.NewMockConn(), config, "test.user@example.com", "oldpass")
+
+	// Empty new password is given explicitly, unlike when password should be generated
+	value := ber.NewSequence("")
+	value.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 1, "oldpass", ""))
+	value.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 2, "", ""))
+
+	rsp := passwordModify(sess, value.Bytes(), config)
+	if rsp.statusCode != ResultConstraintViolation || rsp.errorMessage != constraintViolationMessage("unicodePwd") {
+		t.Errorf("empty new password = %d %q, want constraintViolation for unicodePwd", rsp.statusCode, rsp.errorMessage)
+	}
+	if config.Users[0].Password != "oldpass" {
+		t.Error("failed password change should not update user password")
+	}
+}
+
+func TestPasswordModifyOtherUser(t *testing.T) {
+	conn := mocks.NewMockConn()
+	config := createPasswordTestConfig()
+	sess := createBoundSession(conn, config, "test.user@example.com", "oldpass")
+
+	// Normal users can't reset password of others
+	HandleExtendedRequest(sess, createPasswordModifyPacket("admin@example.com", "", "newpass"), 2, config)
+
+	if code := lastResultCode(t, conn); code != ResultInsufficientAccessRights {
+		t.Errorf("password reset by normal user = %d, want insufficientAccessRights", code)
+	}
+}
+
+func TestPasswordModifyAdminReset(t *testing.T) {
+	conn := mocks.NewMockConn()
+	config := createPasswordTestConfig()
+	sess := createBoundSession(conn, config, "admin@example.com", "adminpass")
+
+	// Target user identified by DN
+	HandleExtendedRequest(sess, createPasswordModifyPacket("CN=Test User,CN=Users,DC=example,DC=com", "", "resetpass"), 2, config)
+
+	if code := lastResultCode(t, conn); code != ResultSuccess {
+		t.Errorf("password reset by administrator = %d, want success", code)
+	}
+	if config.Users[0].Password != "resetpass" {
+		t.Error("password reset should update user password")
+	}
+
+	// Unknown user
+	HandleExtendedRequest(sess, createPasswordModifyPacket("nobody@example.com", "", "resetpass"), 3, config)
+
+	if code := lastResultCode(t, conn); code != ResultNoSuchObject {
+		t.Errorf("password reset of unknown user = %d, want noSuchObject", code)
+	}
+}
+
+func TestPasswordModifyGeneratedPassword(t *testing.T) {
+	conn := mocks.NewMockConn()
+	config := createPasswordTestConfig()
+	sess := createBoundSession(conn, config, "admin@example.com", "adminpass")
+
+	HandleExtendedRequest(sess, createPasswordModifyPacket("u:EXAMPLE\\test.user", "", ""), 2, config)
+
+	value, found := lastResponseValue(t, conn)
+	if !found {
+		t.Fatal("password modify without new password should return generated password")
+	}
+
+	generated := ber.DecodePacket([]byte(value)).Children[0].Data.String()
+	if generated == "" || config.Users[0].Password != generated {
+		t.Error("user password should be set to generated password")
+	}
+}
+
+func TestPasswordModifyAnonymous(t *testing.T) {
+	conn := mocks.NewMockConn()
+	config := createPasswordTestConfig()
+	sess := NewSession(conn)
+
+	HandleExtendedRequest(sess, createPasswordModifyPacket("test.user@example.com", "oldpass", "newpass"), 1, config)
+
+	if code := lastResultCode(t, conn); code != ResultInsufficientAccessRights {
+		t.Errorf("password change on anonymous connection = %d, want insufficientAccessRights", code)
+	}
+}
+
+func TestPasswordModifyAfterRename(t *testing.T) {
+	conn := mocks.NewMockConn()
+	config := createPasswordTestConfig()
+	sess := createBoundSession(conn, config, "test.user@example.com", "oldpass")
+
+	// Own password can be changed after the user is renamed and moved
+	if code := modifyDNEntry(t, config, "CN=Test User,CN=Users,DC=example,DC=com", "CN=Renamed", ""); code != ResultSuccess {
+		t.Fatalf("rename returned %d", code)
+	}
+	if code := modifyEntry(t, config, nil, "CN=Renamed,CN=Users,DC=example,DC=com", newModification(modifyReplace, "userPrincipalName", "renamed@example.com")); code != ResultSuccess {
+		t.Fatalf("modify returned %d", code)
+	}
+
+	HandleExtendedRequest(sess, createPasswordModifyPacket("", "oldpass", "newpass"), 2, config)
+	if code := lastResultCode(t, conn); code != ResultSuccess || config.Users[0].Password != "newpass" {
+		t.Errorf("password change = %d, want success", code)
+	}
+}
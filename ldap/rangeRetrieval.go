@@ -0,0 +1,105 @@
+package ldap
+
+import (
+	"strconv"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Range of values client asked for with 'member;range=0-1499' style attribute description.
+// High is -1 when client asked for all remaining values with '*'.
+type valueRange struct {
+	low  int
+	high int
+}
+
+// How search result entries are built from the objects
+type entryOptions struct {
+	maxValRange   int                   // maximum number of values returned at once, 0 for no limit
+	ranges        map[string]valueRange // requested value ranges, by lower case attribute name
+	attributes    map[string]bool       // attributes requested by name, by lower case name
+	onlyRequested bool                  // user attributes which weren't requested are left out
+	operational   bool                  // all operational attributes were requested with '+'
+	typesOnly     bool                  // attributes are returned without values
+}
+
+// Parses range option of attribute description. Returns false if description has no valid
+// range option.
+func parseRangeOption(description string) (string, valueRange, bool) {
+	parts := strings.Split(description, ";")
+	for _, option := range parts[1:] {
+		rangeValue, ok := strings.CutPrefix(strings.ToLower(option), "range=")
+		if !ok {
+			continue
+		}
+
+		lowValue, highValue, ok := strings.Cut(rangeValue, "-")
+		if !ok {
+			return "", valueRange{}, false
+		}
+
+		low, err := strconv.Atoi(lowValue)
+		if err != nil || low < 0 {
+			return "", valueRange{}, false
+		}
+
+		high := -1
+		if highValue != "*" {
+			if high, err = strconv.Atoi(highValue); err != nil || high < low {
+				return "", valueRange{}, false
+			}
+		}
+
+		return parts[0], valueRange{low: low, high: high}, true
+	}
+
+	return "", valueRange{}, false
+}
+
+// Reads requested value ranges from the attribute list of search request
+func parseRequestedRanges(attributes *ber.Packet) map[string]valueRange {
+	ranges := make(map[string]valueRange)
+	for _, attribute := range attributes.Children {
+		if name, requested, ok := parseRangeOption(attribute.Data.String()); ok {
+			ranges[strings.ToLower(name)] = requested
+		}
+	}
+	return ranges
+}
+
+// Adds values of multi-valued attribute, limited to requested range and the maximum number of
+// values. Partial values are named like 'member;range=0-1499', and the last part of values
+// like 'member;range=1500-*', so that client knows when it has all of them.
+func createRangedAttributePkg(p *ber.Packet, attrType string, values []string, options entryOptions) {
+	requested, ok := options.ranges[strings.ToLower(attrType)]
+	if !ok {
+		if options.maxValRange <= 0 || len(values) <= options.maxValRange {
+			createAttributePkg(p, attrType, values)
+			return
+		}
+		requested = valueRange{low: 0, high: -1}
+	}
+
+	// Nothing to return, when range starts after the last value
+	if requested.low >= len(values) {
+		return
+	}
+
+	high := len(values) - 1
+	if requested.high >= 0 {
+		high = min(high, requested.high)
+	}
+	if options.maxValRange > 0 {
+		high = min(high, requested.low+options.maxValRange-1)
+	}
+
+	rangeName := attrType + ";range=" + strconv.Itoa(requested.low) + "-"
+	if high == len(values)-1 {
+		rangeName += "*"
+	} else {
+		rangeName += strconv.Itoa(high)
+	}
+
+	createAttributePkg(p, rangeName, values[requested.low:high+1])
+}
@@ -0,0 +1,126 @@
+package ldap
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to search the group with given requested attributes, returns the group entry
+func searchGroupEntry(t *testing.T, config *models.AppConfig, attributes ...string) *ber.Packet {
+	searchReq := createSearchRequestPacket("DC=example,DC=com", "(objectClass=group)")
+	attributesPkg := ber.NewSequence("")
+	for _, attribute := range attributes {
+		attributesPkg.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute, ""))
+	}
+	searchReq.AppendChild(attributesPkg)
+
+	conn := mocks.NewMockConn()
+	HandleSearchRequest(createTestSession(conn, true), searchReq, NewOperation(1, 3), config)
+
+	messages := decodeMessages(t, conn)
+	if len(messages) != 2 {
+		t.Fatalf("expected group entry and end of search, got %d messages", len(messages))
+	}
+	return messages[0]
+}
+
+func createRangeTestConfig(memberCount, maxValRange int) *models.AppConfig {
+	var users []models.User
+	for i := range memberCount {
+		cn := fmt.Sprintf("user%d", i)
+		users = append(users, createTestUser(cn, cn+"@example.com", "pass", []string{"group1"}, nil))
+	}
+
+	config := createTestConfigWithUsersAndGroups("example.com", users, []models.Group{createTestGroup("group1")})
+	config.Configuration.MaxValRange = maxValRange
+	return config
+}
+
+func TestParseRangeOption(t *testing.T) {
+	tests := []struct {
+		description string
+		name        string
+		low, high   int
+		ok          bool
+	}{
+		{"member;range=0-1499", "member", 0, 1499, true},
+		{"member;Range=1500-*", "member", 1500, -1, true},
+		{"member;binary;range=3-5", "member", 3, 5, true},
+		{"member", "", 0, 0, false},
+		{"member;range=5-3", "", 0, 0, false},
+		{"member;range=-1-3", "", 0, 0, false},
+		{"member;range=abc", "", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		name, requested, ok := parseRangeOption(tt.description)
+		if ok != tt.ok || name != tt.name || (ok && (requested.low != tt.low || requested.high != tt.high)) {
+			t.Errorf("parseRangeOption(%q) = %q, %v, %v", tt.description, name, requested, ok)
+		}
+	}
+}
+
+func TestGroupMembers(t *testing.T) {
+	config := createRangeTestConfig(2, 0)
+
+	entry := searchGroupEntry(t, config)
+	members := entryAttribute(entry, "member")
+	expected := []string{"CN=user0,CN=Users,DC=example,DC=com", "CN=user1,CN=Users,DC=example,DC=com"}
+	if !slices.Equal(members, expected) {
+		t.Errorf("group should list its members, got %v", members)
+	}
+}
+
+func TestRangedRetrieval(t *testing.T) {
+	config := createRangeTestConfig(5, 2)
+
+	// Without asking for range, only first values are returned
+	entry := searchGroupEntry(t, config)
+	if members := entryAttribute(entry, "member;range=0-1"); len(members) != 2 {
+		t.Errorf("expected first 2 members, got %v", members)
+	}
+	if members := entryAttribute(entry, "member"); members != nil {
+		t.Errorf("member attribute should be returned as range, got %v", members)
+	}
+
+	entry = searchGroupEntry(t, config, "member;range=2-*")
+	if members := entryAttribute(entry, "member;range=2-3"); len(members) != 2 || members[0] != "CN=user2,CN=Users,DC=example,DC=com" {
+		t.Errorf("expected members 2-3, got %v", members)
+	}
+
+	// Last range ends with '*'
+	entry = searchGroupEntry(t, config, "member;range=4-*")
+	if members := entryAttribute(entry, "member;range=4-*"); len(members) != 1 || members[0] != "CN=user4,CN=Users,DC=example,DC=com" {
+		t.Errorf("expected last member, got %v", members)
+	}
+
+	// Range after the last value returns nothing
+	entry = searchGroupEntry(t, config, "member;range=5-*")
+	for _, attr := range entry.Children[1].Children[1].Children {
+		if name := attr.Children[0].Value.(string); strings.HasPrefix(name, "member;") {
+			t.Errorf("unexpected attribute %s", name)
+		}
+	}
+}
+
+func TestRangedRetrievalExplicitEnd(t *testing.T) {
+	config := createRangeTestConfig(5, 0)
+
+	entry := searchGroupEntry(t, config, "member;range=1-2")
+	if members := entryAttribute(entry, "member;range=1-2"); len(members) != 2 {
+		t.Errorf("expected members 1-2, got %v", members)
+	}
+
+	// Small groups are returned as is, when range isn't requested
+	entry = searchGroupEntry(t, config)
+	if members := entryAttribute(entry, "member"); len(members) != 5 {
+		t.Errorf("expected all members, got %v", members)
+	}
+}
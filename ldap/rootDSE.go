@@ -0,0 +1,135 @@
+package ldap
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"smad/models"
+	"strconv"
+	"strings"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Capabilities of AD (Windows Server 2000, 2003, 2008, 2008 R2 and 2012), which tell clients
+// that the server behaves like AD
+var supportedCapabilities = []string{
+	"1.2.840.113556.1.4.800",
+	"1.2.840.113556.1.4.1670",
+	"1.2.840.113556.1.4.1935",
+	"1.2.840.113556.1.4.2080",
+	"1.2.840.113556.1.4.2237",
+}
+
+// Only simple bind is supported, so there are no SASL mechanisms
+var supportedSASLMechanisms []string
+
+// DNS name of the server. Short host name is completed with the domain, like AD's domain
+// controllers are named.
+func dnsHostName(domain string) string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	if !strings.Contains(host, ".") {
+		host += "." + domain
+	}
+	return strings.ToLower(host)
+}
+
+// Request controls of all operations, in a stable order
+func allSupportedControls() []string {
+	var controls []string
+	for _, tag := range slices.Sorted(maps.Keys(supportedControls)) {
+		for _, control := range supportedControls[tag] {
+			if !slices.Contains(controls, control) {
+				controls = append(controls, control)
+			}
+		}
+	}
+	return controls
+}
+
+// Extended operations which work with the configuration. StartTLS needs a certificate.
+func allSupportedExtensions(config *models.AppConfig) []string {
+	var extensions []string
+	for _, name := range slices.Sorted(maps.Keys(extendedOperations)) {
+		if name == startTLSOID && config.TLSConfig == nil {
+			continue
+		}
+		extensions = append(extensions, name)
+	}
+	return extensions
+}
+
+// Root DSE is the entry with empty name, which tells clients where the naming contexts are and
+// what the server supports (RFC 4512, section 5.1). Attributes are in the order AD returns them.
+func rootDSEAttributes(config *models.AppConfig, now time.Time) []partialAttribute {
+	domain := domainDN(config.Configuration.Domain)
+	configurationNC := configurationDN(config.Configuration.Domain)
+	schemaNC := schemaDN(config.Configuration.Domain)
+	host := dnsHostName(config.Configuration.Domain)
+	serverName, _, _ := strings.Cut(host, ".")
+
+	config.RLock()
+	highestUSN := config.HighestUSN
+	config.RUnlock()
+
+	return []partialAttribute{
+		{name: "currentTime", values: []string{now.UTC().Format("20060102150405") + ".0Z"}},
+		{name: "subschemaSubentry", values: []string{subschemaDN(config.Configuration.Domain)}},
+		{name: "serverName", values: []string{"CN=" + strings.ToUpper(serverName) + ",CN=Servers,CN=Default-First-Site-Name,CN=Sites," + configurationNC}},
+		{name: "namingContexts", values: []string{domain, configurationNC, schemaNC}},
+		{name: "defaultNamingContext", values: []string{domain}},
+		{name: "schemaNamingContext", values: []string{schemaNC}},
+		{name: "configurationNamingContext", values: []string{configurationNC}},
+		{name: "rootDomainNamingContext", values: []string{domain}},
+		{name: "supportedControl", values: allSupportedControls()},
+		{name: "supportedLDAPVersion", values: []string{"3"}},
+		{name: "highestCommittedUSN", values: []string{strconv.FormatInt(highestUSN, 10)}},
+		{name: "supportedSASLMechanisms", values: supportedSASLMechanisms},
+		{name: "dnsHostName", values: []string{host}},
+		{name: "supportedCapabilities", values: supportedCapabilities},
+		{name: "supportedExtension", values: allSupportedExtensions(config)},
+	}
+}
+
+// Creates LDAPMessage containing searchResultEntry of the root DSE, with the attributes client
+// asked for. Attributes without values are left out.
+func createRootDSEMessage(msgNum int64, attributes []partialAttribute, options entryOptions) *ber.Packet {
+	rspX := createResponsePacket(msgNum)
+
+	sREPkg := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 0x04, nil, "")
+	sREPkg.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+
+	attrPkg := ber.NewSequence("")
+	for _, attribute := range attributes {
+		if len(attribute.values) == 0 || !options.selected(attribute.name) {
+			continue
+		}
+		if options.typesOnly {
+			createAttributePkg(attrPkg, attribute.name, nil)
+		} else {
+			createAttributePkg(attrPkg, attribute.name, attribute.values)
+		}
+	}
+
+	sREPkg.AppendChild(attrPkg)
+	rspX.AppendChild(sREPkg)
+	return rspX
+}
+
+// Root DSE can be read without bind, so that clients can find out how to bind
+func rootDSESearch(sess *Session, p *ber.Packet, op *Operation, config *models.AppConfig) {
+	options := parseEntryOptions(p, config.Configuration.MaxValRange)
+	if !op.stopped() {
+		sess.Write(createRootDSEMessage(op.ID, rootDSEAttributes(config, time.Now()), options).Bytes())
+	}
+
+	if err := op.finish(); err != nil {
+		sendStoppedResult(sess, op.ID, 0x05, err)
+		return
+	}
+	sendResult(sess, op.ID, 0x05, ldapResult{})
+}
@@ -0,0 +1,105 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to read root DSE without bind. Returns attributes of the entry by name.
+func searchRootDSE(t *testing.T, config *models.AppConfig, attributes ...string) map[string][]string {
+	searchReq := createSearchRequestPacket("", "")
+	searchReq.Children[1] = ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, scopeBaseObject, "")
+	attributesPkg := ber.NewSequence("")
+	for _, attribute := range attributes {
+		attributesPkg.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute, ""))
+	}
+	searchReq.AppendChild(attributesPkg)
+
+	conn := mocks.NewMockConn()
+	HandleSearchRequest(createTestSession(conn, false), searchReq, NewOperation(1, 3), config)
+
+	messages := decodeMessages(t, conn)
+	if len(messages) != 2 || messages[0].Children[1].Children[0].Value.(string) != "" {
+		t.Fatalf("expected root DSE entry and end of search, got %d messages", len(messages))
+	}
+	if code := lastResultCode(t, conn); code != ResultSuccess {
+		t.Fatalf("root DSE search returned %d", code)
+	}
+
+	return entryAttributes(messages[0])
+}
+
+func TestRootDSE(t *testing.T) {
+	config := createTestConfig("example.com")
+	config.HighestUSN = 42
+
+	attributes := searchRootDSE(t, config)
+
+	expected := map[string][]string{
+		"defaultNamingContext":       {"DC=example,DC=com"},
+		"rootDomainNamingContext":    {"DC=example,DC=com"},
+		"configurationNamingContext": {"CN=Configuration,DC=example,DC=com"},
+		"schemaNamingContext":        {"CN=Schema,CN=Configuration,DC=example,DC=com"},
+		"namingContexts":             {"DC=example,DC=com", "CN=Configuration,DC=example,DC=com", "CN=Schema,CN=Configuration,DC=example,DC=com"},
+		"supportedLDAPVersion":       {"3"},
+		"highestCommittedUSN":        {"42"},
+	}
+	for name, values := range expected {
+		if !slices.Equal(attributes[name], values) {
+			t.Errorf("%s = %v, want %v", name, attributes[name], values)
+		}
+	}
+
+	if !slices.Contains(attributes["supportedControl"], pagedResultsOID) || !slices.Contains(attributes["supportedControl"], permissiveModifyOID) {
+		t.Errorf("supportedControl should list supported controls, got %v", attributes["supportedControl"])
+	}
+	if !slices.Contains(attributes["supportedExtension"], whoAmIOID) || slices.Contains(attributes["supportedExtension"], startTLSOID) {
+		t.Errorf("supportedExtension should list extended operations without StartTLS, got %v", attributes["supportedExtension"])
+	}
+	if !slices.Contains(attributes["supportedCapabilities"], "1.2.840.113556.1.4.800") {
+		t.Errorf("supportedCapabilities should tell that server is AD, got %v", attributes["supportedCapabilities"])
+	}
+	if _, ok := attributes["supportedSASLMechanisms"]; ok {
+		t.Error("SASL mechanisms should not be listed, since only simple bind is supported")
+	}
+
+	if host := attributes["dnsHostName"]; len(host) != 1 || !strings.Contains(host[0], ".") {
+		t.Errorf("dnsHostName should be fully qualified, got %v", host)
+	}
+	if server := attributes["serverName"]; len(server) != 1 || !strings.HasSuffix(server[0], ",CN=Servers,CN=Default-First-Site-Name,CN=Sites,CN=Configuration,DC=example,DC=com") {
+		t.Errorf("serverName has wrong value %v", server)
+	}
+	if currentTime := attributes["currentTime"]; len(currentTime) != 1 || !strings.HasSuffix(currentTime[0], ".0Z") {
+		t.Errorf("currentTime should be generalized time, got %v", currentTime)
+	}
+}
+
+func TestRootDSESelectedAttributes(t *testing.T) {
+	config := createTestConfig("example.com")
+	config.TLSConfig = &tls.Config{}
+
+	attributes := searchRootDSE(t, config, "supportedExtension", "DEFAULTNAMINGCONTEXT")
+	if len(attributes) != 2 || len(attributes["defaultNamingContext"]) != 1 {
+		t.Errorf("search should return only requested attributes, got %v", attributes)
+	}
+	if !slices.Contains(attributes["supportedExtension"], startTLSOID) {
+		t.Errorf("StartTLS should be listed when certificate is configured, got %v", attributes["supportedExtension"])
+	}
+}
+
+func TestRootDSECurrentTime(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 700000000, time.UTC)
+	for _, attribute := range rootDSEAttributes(createTestConfig("example.com"), now) {
+		if attribute.name == "currentTime" && !slices.Equal(attribute.values, []string{"20260102030405.0Z"}) {
+			t.Errorf("currentTime = %v", attribute.values)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package ldap
+
+import (
+	"smad/models"
+	"strconv"
+	"strings"
+)
+
+// Syntax of attribute, as AD's attributeSyntax and oMSyntax, and the matching LDAP syntax
+// which is published in attributeTypes
+type attributeSyntax struct {
+	id         string
+	ldapSyntax string
+	omSyntax   int
+}
+
+var (
+	syntaxDN           = attributeSyntax{id: "2.5.5.1", ldapSyntax: "1.3.6.1.4.1.1466.115.121.1.12", omSyntax: 127}
+	syntaxOID          = attributeSyntax{id: "2.5.5.2", ldapSyntax: "1.3.6.1.4.1.1466.115.121.1.38", omSyntax: 6}
+	syntaxBoolean      = attributeSyntax{id: "2.5.5.8", ldapSyntax: "1.3.6.1.4.1.1466.115.121.1.7", omSyntax: 1}
+	syntaxInteger      = attributeSyntax{id: "2.5.5.9", ldapSyntax: "1.3.6.1.4.1.1466.115.121.1.27", omSyntax: 2}
+	syntaxOctetString  = attributeSyntax{id: "2.5.5.10", ldapSyntax: "1.3.6.1.4.1.1466.115.121.1.40", omSyntax: 4}
+	syntaxUnicode      = attributeSyntax{id: "2.5.5.12", ldapSyntax: "1.3.6.1.4.1.1466.115.121.1.15", omSyntax: 64}
+	syntaxLargeInteger = attributeSyntax{id: "2.5.5.16", ldapSyntax: "1.2.840.113556.1.4.906", omSyntax: 65}
+)
+
+// Attribute definition, published as attributeSchema entry and in attributeTypes
+type attributeType struct {
+	cn           string
+	name         string
+	oid          string
+	syntax       attributeSyntax
+	singleValued bool
+	systemOnly   bool
+}
+
+// Categories of object classes (AD's objectClassCategory)
+const (
+	categoryStructural = 1
+	categoryAbstract   = 2
+)
+
+// Object class definition, published as classSchema entry and in objectClasses
+type objectClass struct {
+	cn       string
+	name     string
+	oid      string
+	superior string
+	category int
+	must     []string
+	may      []string
+}
+
+// Attributes which the server knows, with the names and identifiers AD uses
+var schemaAttributeTypes = []attributeType{
+	{cn: "Object-Class", name: "objectClass", oid: "2.5.4.0", syntax: syntaxOID},
+	{cn: "Common-Name", name: "cn", oid: "2.5.4.3", syntax: syntaxUnicode, singleValued: true},
+	{cn: "Surname", name: "sn", oid: "2.5.4.4", syntax: syntaxUnicode, singleValued: true},
+	{cn: "Organizational-Unit-Name", name: "ou", oid: "2.5.4.11", syntax: syntaxUnicode},
+	{cn: "Title", name: "title", oid: "2.5.4.12", syntax: syntaxUnicode, singleValued: true},
+	{cn: "Description", name: "description", oid: "2.5.4.13", syntax: syntaxUnicode},
+	{cn: "Telephone-Number", name: "telephoneNumber", oid: "2.5.4.20", syntax: syntaxUnicode, singleValued: true},
+	{cn: "Member", name: "member", oid: "2.5.4.31", syntax: syntaxDN},
+	{cn: "Given-Name", name: "givenName", oid: "2.5.4.42", syntax: syntaxUnicode, singleValued: true},
+	{cn: "Initials", name: "initials", oid: "2.5.4.43", syntax: syntaxUnicode, singleValued: true},
+	{cn: "SubSchemaSubEntry", name: "subschemaSubentry", oid: "2.5.18.10", syntax: syntaxDN, systemOnly: true},
+	{cn: "Attribute-Types", name: "attributeTypes", oid: "2.5.21.5", syntax: syntaxUnicode},
+	{cn: "Object-Classes", name: "objectClasses", oid: "2.5.21.6", syntax: syntaxUnicode},
+	{cn: "E-mail-Addresses", name: "mail", oid: "0.9.2342.19200300.100.1.3", syntax: syntaxUnicode, singleValued: true},
+	{cn: "Domain-Component", name: "dc", oid: "0.9.2342.19200300.100.1.25", syntax: syntaxUnicode, singleValued: true},
+	{cn: "Display-Name", name: "displayName", oid: "1.2.840.113556.1.2.13", syntax: syntaxUnicode, singleValued: true},
+	{cn: "USN-Created", name: "uSNCreated", oid: "1.2.840.113556.1.2.19", syntax: syntaxLargeInteger, singleValued: true, systemOnly: true},
+	{cn: "Sub-Class-Of", name: "subClassOf", oid: "1.2.840.113556.1.2.21", syntax: syntaxOID, singleValued: true},
+	{cn: "Governs-ID", name: "governsID", oid: "1.2.840.113556.1.2.22", syntax: syntaxOID, singleValued: true},
+	{cn: "Must-Contain", name: "mustContain", oid: "1.2.840.113556.1.2.24", syntax: syntaxOID},
+	{cn: "May-Contain", name: "mayContain", oid: "1.2.840.113556.1.2.25", syntax: syntaxOID},
+	{cn: "Attribute-ID", name: "attributeID", oid: "1.2.840.113556.1.2.30", syntax: syntaxOID, singleValued: true},
+	{cn: "Attribute-Syntax", name: "attributeSyntax", oid: "1.2.840.113556.1.2.32", syntax: syntaxOID, singleValued: true},
+	{cn: "Is-Single-Valued", name: "isSingleValued", oid: "1.2.840.113556.1.2.33", syntax: syntaxBoolean, singleValued: true},
+	{cn: "Is-Deleted", name: "isDeleted", oid: "1.2.840.113556.1.2.48", syntax: syntaxBoolean, singleValued: true, systemOnly: true},
+	{cn: "Is-Member-Of-DL", name: "memberOf", oid: "1.2.840.113556.1.2.102", syntax: syntaxDN, systemOnly: true},
+	{cn: "USN-Changed", name: "uSNChanged", oid: "1.2.840.113556.1.2.120", syntax: syntaxLargeInteger, singleValued: true, systemOnly: true},
+	{cn: "Department", name: "department", oid: "1.2.840.113556.1.2.141", syntax: syntaxUnicode, singleValued: true},
+	{cn: "Company", name: "company", oid: "1.2.840.113556.1.2.146", syntax: syntaxUnicode, singleValued: true},
+	{cn: "OM-Syntax", name: "oMSyntax", oid: "1.2.840.113556.1.2.231", syntax: syntaxInteger, singleValued: true},
+	{cn: "Object-Class-Category", name: "objectClassCategory", oid: "1.2.840.113556.1.2.370", syntax: syntaxInteger, singleValued: true},
+	{cn: "LDAP-Display-Name", name: "lDAPDisplayName", oid: "1.2.840.113556.1.2.460", syntax: syntaxUnicode, singleValued: true},
+	{cn: "RDN", name: "name", oid: "1.2.840.113556.1.4.1", syntax: syntaxUnicode, singleValued: true, systemOnly: true},
+	{cn: "Object-Guid", name: "objectGUID", oid: "1.2.840.113556.1.4.2", syntax: syntaxOctetString, singleValued: true, systemOnly: true},
+	{cn: "User-Account-Control", name: "userAccountControl", oid: "1.2.840.113556.1.4.8", syntax: syntaxInteger, singleValued: true},
+	{cn: "Unicode-Pwd", name: "unicodePwd", oid: "1.2.840.113556.1.4.90", syntax: syntaxOctetString, singleValued: true},
+	{cn: "Logon-Count", name: "logonCount", oid: "1.2.840.113556.1.4.169", syntax: syntaxInteger, singleValued: true},
+	{cn: "System-Only", name: "systemOnly", oid: "1.2.840.113556.1.4.170", syntax: syntaxBoolean, singleValued: true},
+	{cn: "SAM-Account-Name", name: "sAMAccountName", oid: "1.2.840.113556.1.4.221", syntax: syntaxUnicode, singleValued: true},
+	{cn: "DNS-Host-Name", name: "dNSHostName", oid: "1.2.840.113556.1.4.619", syntax: syntaxUnicode, singleValued: true},
+	{cn: "User-Principal-Name", name: "userPrincipalName", oid: "1.2.840.113556.1.4.656", syntax: syntaxUnicode, singleValued: true},
+	{cn: "Last-Known-Parent", name: "lastKnownParent", oid: "1.2.840.113556.1.4.781", syntax: syntaxDN, singleValued: true, systemOnly: true},
+	{cn: "Canonical-Name", name: "canonicalName", oid: "1.2.840.113556.1.4.916", syntax: syntaxUnicode, systemOnly: true},
+}
+
+// Object classes of the objects in the directory and in the schema. Only attributes which
+// the server knows are listed in must and may.
+var schemaObjectClasses = []objectClass{
+	{cn: "Top", name: "top", oid: "2.5.6.0", category: categoryAbstract,
+		must: []string{"objectClass"},
+		may:  []string{"canonicalName", "description", "isDeleted", "lastKnownParent", "memberOf", "name", "objectGUID", "subschemaSubentry", "uSNChanged", "uSNCreated"}},
+	{cn: "Person", name: "person", oid: "2.5.6.6", superior: "top", category: categoryStructural,
+		must: []string{"cn"},
+		may:  []string{"sn", "telephoneNumber"}},
+	{cn: "Organizational-Person", name: "organizationalPerson", oid: "2.5.6.7", superior: "person", category: categoryStructural,
+		may: []string{"company", "department", "displayName", "givenName", "initials", "mail", "title"}},
+	{cn: "User", name: "user", oid: "1.2.840.113556.1.5.9", superior: "organizationalPerson", category: categoryStructural,
+		may: []string{"logonCount", "sAMAccountName", "unicodePwd", "userAccountControl", "userPrincipalName"}},
+	{cn: "Computer", name: "computer", oid: "1.2.840.113556.1.3.30", superior: "user", category: categoryStructural,
+		may: []string{"dNSHostName"}},
+	{cn: "Contact", name: "contact", oid: "1.2.840.113556.1.5.15", superior: "organizationalPerson", category: categoryStructural,
+		must: []string{"cn"}},
+	{cn: "Group", name: "group", oid: "1.2.840.113556.1.5.8", superior: "top", category: categoryStructural,
+		may: []string{"displayName", "mail", "member", "sAMAccountName"}},
+	{cn: "Organizational-Unit", name: "organizationalUnit", oid: "2.5.6.5", superior: "top", category: categoryStructural,
+		must: []string{"ou"}},
+	{cn: "Container", name: "container", oid: "1.2.840.113556.1.3.23", superior: "top", category: categoryStructural,
+		must: []string{"cn"}},
+	{cn: "Domain", name: "domain", oid: "1.2.840.113556.1.5.66", superior: "top", category: categoryAbstract,
+		must: []string{"dc"}},
+	{cn: "Domain-DNS", name: "domainDNS", oid: "1.2.840.113556.1.5.67", superior: "domain", category: categoryStructural},
+	{cn: "Configuration", name: "configuration", oid: "1.2.840.113556.1.5.12", superior: "top", category: categoryStructural,
+		must: []string{"cn"}},
+	{cn: "DMD", name: "dMD", oid: "1.2.840.113556.1.3.9", superior: "top", category: categoryStructural,
+		must: []string{"cn"}},
+	{cn: "SubSchema", name: "subSchema", oid: "2.5.20.1", superior: "top", category: categoryStructural,
+		may: []string{"attributeTypes", "objectClasses"}},
+	{cn: "Attribute-Schema", name: "attributeSchema", oid: "1.2.840.113556.1.3.14", superior: "top", category: categoryStructural,
+		must: []string{"attributeID", "attributeSyntax", "cn", "isSingleValued", "lDAPDisplayName", "oMSyntax"},
+		may:  []string{"systemOnly"}},
+	{cn: "Class-Schema", name: "classSchema", oid: "1.2.840.113556.1.3.13", superior: "top", category: categoryStructural,
+		must: []string{"cn", "governsID", "objectClassCategory", "subClassOf"},
+		may:  []string{"lDAPDisplayName", "mayContain", "mustContain"}},
+}
+
+// Name of the attribute as the schema spells it, so that attributes set by clients are stored
+// under the same key whatever case client used. Unknown attributes keep the given name.
+func canonicalAttributeName(name string) string {
+	for _, attribute := range schemaAttributeTypes {
+		if strings.EqualFold(attribute.name, name) {
+			return attribute.name
+		}
+	}
+	return name
+}
+
+func configurationDN(domain string) string {
+	return "CN=Configuration," + domainDN(domain)
+}
+
+func schemaDN(domain string) string {
+	return "CN=Schema," + configurationDN(domain)
+}
+
+// Subschema entry publishes the schema for LDAP clients (RFC 4512, section 4.2)
+func subschemaDN(domain string) string {
+	return "CN=Aggregate," + schemaDN(domain)
+}
+
+func schemaBoolean(value bool) string {
+	if value {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// Attribute type description in the format AD uses in attributeTypes (RFC 4512, section 4.1.2)
+func (a attributeType) definition() string {
+	definition := "( " + a.oid + " NAME '" + a.name + "' SYNTAX '" + a.syntax.ldapSyntax + "'"
+	if a.singleValued {
+		definition += " SINGLE-VALUE"
+	}
+	if a.systemOnly {
+		definition += " NO-USER-MODIFICATION"
+	}
+	return definition + " )"
+}
+
+// Object class description in the format AD uses in objectClasses (RFC 4512, section 4.1.1)
+func (c objectClass) definition() string {
+	definition := "( " + c.oid + " NAME '" + c.name + "'"
+	if c.superior != "" {
+		definition += " SUP " + c.superior
+	}
+	if c.category == categoryAbstract {
+		definition += " ABSTRACT"
+	} else {
+		definition += " STRUCTURAL"
+	}
+	if len(c.must) > 0 {
+		definition += " MUST ( " + strings.Join(c.must, " $ ") + " )"
+	}
+	if len(c.may) > 0 {
+		definition += " MAY ( " + strings.Join(c.may, " $ ") + " )"
+	}
+	return definition + " )"
+}
+
+// Entries of configuration naming context. Schema is under it in the tree, but it's a naming
+// context of its own.
+func configurationElements(config *models.AppConfig) []models.LdapElement {
+	return []models.LdapElement{{
+		DN:                 configurationDN(config.Configuration.Domain),
+		Cn:                 "Configuration",
+		ObjectClass:        []string{"top", "configuration"},
+		Attributes:         map[string]string{"name": "Configuration"},
+		UserAccountControl: -1,
+	}}
+}
+
+// Entries of schema naming context: the schema container, the subschema entry and
+// attributeSchema and classSchema entry for every definition. Schema doesn't change, so the
+// entries don't have update sequence numbers.
+func schemaElements(config *models.AppConfig) []models.LdapElement {
+	domain := config.Configuration.Domain
+
+	var attributeTypes, objectClasses []string
+	for _, attribute := range schemaAttributeTypes {
+		attributeTypes = append(attributeTypes, attribute.definition())
+	}
+	for _, class := range schemaObjectClasses {
+		objectClasses = append(objectClasses, class.definition())
+	}
+
+	elements := []models.LdapElement{
+		{
+			DN:                 schemaDN(domain),
+			Cn:                 "Schema",
+			ObjectClass:        []string{"top", "dMD"},
+			Attributes:         map[string]string{"name": "Schema"},
+			UserAccountControl: -1,
+		},
+		{
+			DN:                 subschemaDN(domain),
+			Cn:                 "Aggregate",
+			ObjectClass:        []string{"top", "subSchema"},
+			Attributes:         map[string]string{"name": "Aggregate"},
+			MultiValued:        map[string][]string{"attributeTypes": attributeTypes, "objectClasses": objectClasses},
+			UserAccountControl: -1,
+		},
+	}
+
+	for _, attribute := range schemaAttributeTypes {
+		elements = append(elements, models.LdapElement{
+			DN:          "CN=" + attribute.cn + "," + schemaDN(domain),
+			Cn:          attribute.cn,
+			ObjectClass: []string{"top", "attributeSchema"},
+			Attributes: map[string]string{
+				"name":            attribute.cn,
+				"lDAPDisplayName": attribute.name,
+				"attributeID":     attribute.oid,
+				"attributeSyntax": attribute.syntax.id,
+				"oMSyntax":        strconv.Itoa(attribute.syntax.omSyntax),
+				"isSingleValued":  schemaBoolean(attribute.singleValued),
+				"systemOnly":      schemaBoolean(attribute.systemOnly),
+			},
+			UserAccountControl: -1,
+		})
+	}
+
+	for _, class := range schemaObjectClasses {
+		// Top is its own superclass in AD. Like AD, classes and attributes are referred to by
+		// name, even if values have OID syntax.
+		superior := class.superior
+		if superior == "" {
+			superior = class.name
+		}
+
+		element := models.LdapElement{
+			DN:          "CN=" + class.cn + "," + schemaDN(domain),
+			Cn:          class.cn,
+			ObjectClass: []string{"top", "classSchema"},
+			Attributes: map[string]string{
+				"name":                class.cn,
+				"lDAPDisplayName":     class.name,
+				"governsID":           class.oid,
+				"subClassOf":          superior,
+				"objectClassCategory": strconv.Itoa(class.category),
+			},
+			MultiValued:        make(map[string][]string),
+			UserAccountControl: -1,
+		}
+		if len(class.must) > 0 {
+			element.MultiValued["mustContain"] = class.must
+		}
+		if len(class.may) > 0 {
+			element.MultiValued["mayContain"] = class.may
+		}
+		elements = append(elements, element)
+	}
+
+	return elements
+}
+
+// Entries of configuration or schema naming context, when base is in one of them. Searches
+// don't cross from one naming context to another.
+func namingContextElements(config *models.AppConfig, baseDN string) ([]models.LdapElement, bool) {
+	domain := config.Configuration.Domain
+	switch {
+	case isSameOrUnder(baseDN, schemaDN(domain)):
+		return schemaElements(config), true
+	case isSameOrUnder(baseDN, configurationDN(domain)):
+		return configurationElements(config), true
+	}
+	return nil, false
+}
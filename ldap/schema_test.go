@@ -0,0 +1,114 @@
+package ldap
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to read one entry with base scope search. Returns attributes of the entry
+// by name.
+func readEntry(t *testing.T, config *models.AppConfig, dn string) map[string][]string {
+	searchReq := createSearchRequestPacket(dn, "")
+	searchReq.Children[1] = ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, scopeBaseObject, "")
+
+	conn := mocks.NewMockConn()
+	HandleSearchRequest(createTestSession(conn, true), searchReq, NewOperation(1, 3), config)
+
+	messages := decodeMessages(t, conn)
+	if len(messages) != 2 {
+		t.Fatalf("expected entry %s and end of search, got %d messages", dn, len(messages))
+	}
+
+	return entryAttributes(messages[0])
+}
+
+func TestSubschemaEntry(t *testing.T) {
+	config := createTestConfig("example.com")
+	subschema := readEntry(t, config, "CN=Aggregate,CN=Schema,CN=Configuration,DC=example,DC=com")
+
+	for _, class := range []string{"top", "person", "organizationalPerson", "user", "group", "organizationalUnit", "computer", "contact"} {
+		if !slices.ContainsFunc(subschema["objectClasses"], func(definition string) bool { return strings.Contains(definition, " NAME '"+class+"' ") }) {
+			t.Errorf("objectClasses should have %s", class)
+		}
+	}
+	if !slices.Contains(subschema["objectClasses"], "( 1.2.840.113556.1.5.9 NAME 'user' SUP organizationalPerson STRUCTURAL MAY ( logonCount $ sAMAccountName $ unicodePwd $ userAccountControl $ userPrincipalName ) )") {
+		t.Error("objectClasses should describe user class")
+	}
+	if !slices.Contains(subschema["attributeTypes"], "( 1.2.840.113556.1.4.221 NAME 'sAMAccountName' SYNTAX '1.3.6.1.4.1.1466.115.121.1.15' SINGLE-VALUE )") {
+		t.Error("attributeTypes should describe sAMAccountName")
+	}
+}
+
+func TestSchemaEntries(t *testing.T) {
+	config := createTestConfig("example.com")
+
+	classSchema := readEntry(t, config, "cn=organizational-person,cn=schema,cn=configuration,dc=example,dc=com")
+	if !slices.Equal(classSchema["objectClass"], []string{"top", "classSchema"}) || !slices.Equal(classSchema["subClassOf"], []string{"person"}) ||
+		!slices.Equal(classSchema["governsID"], []string{"2.5.6.7"}) || !slices.Contains(classSchema["mayContain"], "givenName") {
+		t.Errorf("organizationalPerson has wrong classSchema entry %v", classSchema)
+	}
+
+	attributeSchema := readEntry(t, config, "CN=Is-Member-Of-DL,CN=Schema,CN=Configuration,DC=example,DC=com")
+	if !slices.Equal(attributeSchema["lDAPDisplayName"], []string{"memberOf"}) || !slices.Equal(attributeSchema["attributeSyntax"], []string{"2.5.5.1"}) ||
+		!slices.Equal(attributeSchema["isSingleValued"], []string{"FALSE"}) || !slices.Equal(attributeSchema["systemOnly"], []string{"TRUE"}) {
+		t.Errorf("memberOf has wrong attributeSchema entry %v", attributeSchema)
+	}
+
+	// Schema is not part of the domain
+	names, _, _ := searchScope(t, config, "DC=example,DC=com", scopeWholeSubtree)
+	if slices.ContainsFunc(names, func(name string) bool { return strings.HasSuffix(name, "CN=Configuration,DC=example,DC=com") }) {
+		t.Errorf("domain search should not return configuration naming context, got %v", names)
+	}
+	if names, _, _ := searchScope(t, config, "CN=Schema,CN=Configuration,DC=example,DC=com", scopeSingleLevel); len(names) != len(schemaAttributeTypes)+len(schemaObjectClasses)+1 {
+		t.Errorf("schema should have subschema entry and entry for every definition, got %d entries", len(names))
+	}
+
+	// Schema is a naming context of its own, so configuration searches don't return it
+	if names, _, _ := searchScope(t, config, "CN=Configuration,DC=example,DC=com", scopeWholeSubtree); !slices.Equal(names, []string{"CN=Configuration,DC=example,DC=com"}) {
+		t.Errorf("configuration search should only return configuration naming context, got %v", names)
+	}
+}
+
+func TestSchemaIsConsistent(t *testing.T) {
+	attributes := make(map[string]bool)
+	for _, attribute := range schemaAttributeTypes {
+		if attributes[attribute.name] {
+			t.Errorf("attribute %s is defined twice", attribute.name)
+		}
+		attributes[attribute.name] = true
+	}
+
+	classes := make(map[string]bool)
+	for _, class := range schemaObjectClasses {
+		classes[class.name] = true
+	}
+
+	for _, class := range schemaObjectClasses {
+		if class.superior != "" && !classes[class.superior] {
+			t.Errorf("superior %s of %s is not defined", class.superior, class.name)
+		}
+		for _, name := range append(slices.Clone(class.must), class.may...) {
+			if !attributes[name] {
+				t.Errorf("attribute %s of %s is not defined", name, class.name)
+			}
+		}
+	}
+}
+
+func TestSubschemaSubentryAttribute(t *testing.T) {
+	attributes := searchUserAttributes(t, false, "subschemaSubentry")
+	if !slices.Equal(attributes["subschemaSubentry"], []string{"CN=Aggregate,CN=Schema,CN=Configuration,DC=example,DC=com"}) {
+		t.Errorf("subschemaSubentry = %v", attributes["subschemaSubentry"])
+	}
+
+	rootDSE := searchRootDSE(t, createTestConfig("example.com"), "subschemaSubentry")
+	if !slices.Equal(rootDSE["subschemaSubentry"], []string{"CN=Aggregate,CN=Schema,CN=Configuration,DC=example,DC=com"}) {
+		t.Errorf("root DSE subschemaSubentry = %v", rootDSE["subschemaSubentry"])
+	}
+}
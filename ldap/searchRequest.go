@@ -3,29 +3,23 @@ package ldap
 import (
 	"fmt"
 	"log"
-	"net"
+	"maps"
 	"slices"
 	"smad/models"
 	"strconv"
 	"strings"
+	"time"
 
 	ber "github.com/go-asn1-ber/asn1-ber"
 )
 
 func addEndOfSearchPkg(rsp *ber.Packet, statusCode int, errorMessage string) {
 	// Create end of search result packet
-	searchRspPacket := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 0x05, nil, "")
-	codePacket := ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, statusCode, "")
-	searchRspPacket.AppendChild(codePacket)
-	dnPacket := ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "")
-	searchRspPacket.AppendChild(dnPacket)
-	msgPacket := ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, errorMessage, "")
-	searchRspPacket.AppendChild(msgPacket)
-	rsp.AppendChild(searchRspPacket)
+	rsp.AppendChild(createResultPkg(0x05, statusCode, "", errorMessage))
 }
 
 func createObjectName(cn, prefix, domain string) string {
-	objName := "CN=" + cn + "," + prefix
+	objName := "CN=" + escapeRDNValue(cn) + "," + prefix
 
 	domainParts := strings.Split(domain, ".")
 	for _, part := range domainParts {
@@ -101,32 +95,225 @@ func createSearchResEntry(objectName string, objectClasses []string, attributes
 	return attrPacket, searchResEntry
 }
 
-func joinGroupsAndUsers(config models.AppConfig) []models.LdapElement {
+// Creates LDAPMessage containing searchResultEntry of the object, with the attributes client
+// asked for
+func createSearchResEntryMessage(msgNum int64, object models.LdapElement, options entryOptions) *ber.Packet {
+	rspX := createResponsePacket(msgNum)
+
+	sREPkg := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 0x04, nil, "")
+	sREPkg.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, object.DN, ""))
+	attrPkg := ber.NewSequence("")
+
+	// Large multi-valued attributes are returned in ranges, and typesOnly search returns only
+	// names of the attributes
+	addAttribute := func(attrType string, values []string, ranged bool) {
+		switch {
+		case !options.selected(attrType):
+		case options.typesOnly:
+			createAttributePkg(attrPkg, attrType, nil)
+		case ranged:
+			createRangedAttributePkg(attrPkg, attrType, values, options)
+		default:
+			createAttributePkg(attrPkg, attrType, values)
+		}
+	}
+
+	addAttribute("objectClass", object.ObjectClass, false)
+	for key, value := range object.Attributes {
+		addAttribute(key, []string{value}, false)
+	}
+
+	// Add CN
+	if object.Cn != "" {
+		addAttribute("cn", []string{object.Cn}, false)
+	}
+
+	// Add memberof and member packages
+	if len(object.MemberOf) > 0 {
+		addAttribute("memberOf", object.MemberOf, true)
+	}
+	if len(object.Member) > 0 {
+		addAttribute("member", object.Member, true)
+	}
+	for key, values := range object.MultiValued {
+		addAttribute(key, values, false)
+	}
+
+	if object.UserAccountControl > 0 {
+		uacStr := strconv.Itoa(object.UserAccountControl)
+		addAttribute("userAccountControl", []string{uacStr}, false)
+	}
+
+	if object.ObjectGUID != "" {
+		addAttribute("objectGUID", []string{encodeGUID(object.ObjectGUID)}, false)
+	}
+
+	if object.USNCreated > 0 {
+		addAttribute("uSNCreated", []string{strconv.FormatInt(object.USNCreated, 10)}, false)
+		addAttribute("uSNChanged", []string{strconv.FormatInt(object.USNChanged, 10)}, false)
+	}
+
+	addAttribute("canonicalName", []string{canonicalName(object.DN)}, false)
+	addAttribute("subschemaSubentry", []string{subschemaDN(domainOfDN(object.DN))}, false)
+
+	// Attach attributes to response
+	sREPkg.AppendChild(attrPkg)
+	rspX.AppendChild(sREPkg)
+	return rspX
+}
+
+func cloneUSN(usn models.USN) models.USN {
+	usn.AttributeUSNs = maps.Clone(usn.AttributeUSNs)
+	return usn
+}
+
+func groupElement(config *models.AppConfig, group models.Group) models.LdapElement {
+	newItem := models.LdapElement{Cn: group.Cn, ObjectGUID: group.ObjectGUID, UserAccountControl: -1, USN: cloneUSN(group.USN)}
+	newItem.DN = "CN=" + escapeRDNValue(group.Cn) + "," + containerDN(group.Container, config.Configuration.Domain)
+	newItem.ObjectClass = []string{"top", "group"}
+	newItem.Attributes = maps.Clone(group.Attributes)
+	if newItem.Attributes == nil {
+		newItem.Attributes = make(map[string]string)
+	}
+	newItem.Attributes["name"] = group.Cn
+	return newItem
+}
+
+func userElement(config *models.AppConfig, user models.User) models.LdapElement {
+	newItem := models.LdapElement{Cn: user.Cn, ObjectGUID: user.ObjectGUID, UserAccountControl: user.UserAccountControl, USN: cloneUSN(user.USN)}
+	newItem.DN = userDN(user, config.Configuration.Domain)
+	newItem.ObjectClass = []string{"top", "person", "organizationalPerson", "user"}
+	newItem.Attributes = maps.Clone(user.Attributes)
+
+	for _, ug := range user.Groups {
+		newItem.MemberOf = append(newItem.MemberOf, groupDN(config, ug))
+	}
+
+	return newItem
+}
+
+// OUs don't have cn, they are named by ou attribute
+func ouElement(config *models.AppConfig, ou models.OrganizationalUnit) models.LdapElement {
+	newItem := models.LdapElement{ObjectGUID: ou.ObjectGUID, UserAccountControl: -1, USN: cloneUSN(ou.USN)}
+	newItem.DN = ouDN(ou, config.Configuration.Domain)
+	newItem.ObjectClass = []string{"top", "organizationalUnit"}
+	newItem.Attributes = maps.Clone(ou.Attributes)
+	if newItem.Attributes == nil {
+		newItem.Attributes = make(map[string]string)
+	}
+	newItem.Attributes["ou"] = ou.Ou
+	newItem.Attributes["name"] = ou.Ou
+	return newItem
+}
+
+// Domain object and the built-in container for users and groups, which always exist
+func containerElements(config *models.AppConfig) []models.LdapElement {
+	domain := config.Configuration.Domain
+	dc, _, _ := strings.Cut(domain, ".")
+
+	return []models.LdapElement{
+		{
+			DN:                 domainDN(domain),
+			ObjectClass:        []string{"top", "domain", "domainDNS"},
+			Attributes:         map[string]string{"dc": dc, "name": dc},
+			UserAccountControl: -1,
+		},
+		{
+			DN:                 containerDN("", domain),
+			Cn:                 "Users",
+			ObjectClass:        []string{"top", "container"},
+			Attributes:         map[string]string{"name": "Users", "description": "Default container for upgraded user accounts"},
+			UserAccountControl: -1,
+		},
+	}
+}
+
+// Search scopes (RFC 4511, section 4.5.1.2)
+const (
+	scopeBaseObject   = 0
+	scopeSingleLevel  = 1
+	scopeWholeSubtree = 2
+)
+
+// Objects which are within the scope of the search base
+func objectsInScope(objects []models.LdapElement, baseDN string, scope int64) []models.LdapElement {
+	base := normalizeDN(baseDN)
+
+	var found []models.LdapElement
+	for _, object := range objects {
+		dn := normalizeDN(object.DN)
+		_, _, parent := splitDN(object.DN)
+
+		if (scope == scopeBaseObject && dn == base) ||
+			(scope == scopeSingleLevel && normalizeDN(parent) == base) ||
+			(scope == scopeWholeSubtree && isSameOrUnder(dn, base)) {
+			found = append(found, object)
+		}
+	}
+	return found
+}
+
+func joinGroupsAndUsers(config *models.AppConfig) []models.LdapElement {
 	var allItems []models.LdapElement
+	groupIndexes := make(map[string]int)
+
+	for _, ou := range config.OrganizationalUnits {
+		allItems = append(allItems, ouElement(config, ou))
+	}
 
 	for _, group := range config.Groups {
-		newItem := models.LdapElement{Cn: group.Cn, UserAccountControl: -1}
-		newItem.ObjectClass = []string{"top", "group"}
-		newItem.Attributes = map[string]string{"name": group.Cn}
-		allItems = append(allItems, newItem)
+		groupIndexes[group.Cn] = len(allItems)
+		allItems = append(allItems, groupElement(config, group))
 	}
 
+	// Member attribute of the group lists users who have the group in their groups
 	for _, user := range config.Users {
-		newItem := models.LdapElement{Cn: user.Cn, UserAccountControl: user.UserAccountControl}
-		newItem.ObjectClass = []string{"top", "person", "organizationalPerson", "user"}
-		newItem.Attributes = user.Attributes
-
+		userItem := userElement(config, user)
 		for _, ug := range user.Groups {
-			groupName := createObjectName(ug, "CN=Users", config.Configuration.Domain)
-			newItem.MemberOf = append(newItem.MemberOf, groupName)
+			if idx, ok := groupIndexes[ug]; ok {
+				allItems[idx].Member = append(allItems[idx].Member, userItem.DN)
+			}
 		}
-
-		allItems = append(allItems, newItem)
+		allItems = append(allItems, userItem)
 	}
 
 	return allItems
 }
 
+// Returns values of given attribute of the element, attribute names are case-insensitive
+func attributeValues(element models.LdapElement, attribute string) []string {
+	switch strings.ToLower(attribute) {
+	case "cn":
+		if element.Cn != "" {
+			return []string{element.Cn}
+		}
+		return nil
+	case "objectclass":
+		return element.ObjectClass
+	case "memberof":
+		return element.MemberOf
+	case "member":
+		return element.Member
+	case "useraccountcontrol":
+		if element.UserAccountControl > 0 {
+			return []string{strconv.Itoa(element.UserAccountControl)}
+		}
+		return nil
+	}
+
+	for key, value := range element.Attributes {
+		if strings.EqualFold(key, attribute) {
+			return []string{value}
+		}
+	}
+	for key, values := range element.MultiValued {
+		if strings.EqualFold(key, attribute) {
+			return values
+		}
+	}
+	return nil
+}
+
 func createFilter(rawFilter *ber.Packet) models.LdapFilter {
 	var filter models.LdapFilter
 
@@ -226,76 +413,288 @@ func filterObjects(rawData []models.LdapElement, filters *ber.Packet) []models.L
 	return filteredElements
 }
 
-func HandleSearchRequest(conn net.Conn, p *ber.Packet, msgNum uint8, bindSuccessful bool, config models.AppConfig) {
-	if len(p.Children) < 6 {
-		log.Println("Unsupported search package")
+// Sends entries of search result, until all are sent, search is stopped or time limit (if not
+// zero) is reached. Returns false, if time ran out.
+func sendSearchEntries(sess *Session, op *Operation, objects []models.LdapElement, options entryOptions, deadline time.Time) bool {
+	for _, object := range objects {
+		if op.stopped() {
+			break
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return false
+		}
+
+		sess.Write(createSearchResEntryMessage(op.ID, object, options).Bytes())
+	}
+	return true
+}
+
+func HandleSearchRequest(sess *Session, p *ber.Packet, op *Operation, config *models.AppConfig) {
+	eosp := createResponsePacket(op.ID)
+
+	// Attribute list (IDX 7) is optional for clients which want all attributes, filter isn't
+	if len(p.Children) < 7 {
+		addEndOfSearchPkg(eosp, ResultProtocolError, DecodingErrorMessage)
+		sess.Write(eosp.Bytes())
+		return
+	}
+
+	// Client's size limit (entries) and time limit (seconds), 0 means no limit. Aliases don't
+	// exist in AD, so derefAliases (IDX 2) doesn't matter.
+	baseDN := fmt.Sprintf("%v", p.Children[0].Value)
+	scope, scopeErr := ber.ParseInt64(p.Children[1].Data.Bytes())
+	sizeLimit, sizeErr := ber.ParseInt64(p.Children[3].Data.Bytes())
+	timeLimit, timeErr := ber.ParseInt64(p.Children[4].Data.Bytes())
+	if scopeErr != nil || sizeErr != nil || timeErr != nil || scope < scopeBaseObject || scope > scopeWholeSubtree || sizeLimit < 0 || timeLimit < 0 {
+		addEndOfSearchPkg(eosp, ResultProtocolError, DecodingErrorMessage)
+		sess.Write(eosp.Bytes())
 		return
 	}
 
-	eosp := createResponsePacket(msgNum)
+	// Root DSE is the only thing which can be searched without bind
+	if baseDN == "" && scope == scopeBaseObject {
+		rootDSESearch(sess, p, op, config)
+		return
+	}
 
-	if !bindSuccessful {
-		addEndOfSearchPkg(eosp, 1, "000004DC: LdapErr: DSID-0C090CF4, comment: In order to perform this operation a successful bind must be completed on the connection., data 0, v4563")
-		conn.Write(eosp.Bytes())
+	if !sess.BindSuccessful {
+		addEndOfSearchPkg(eosp, 1, bindRequiredMessage)
+		sess.Write(eosp.Bytes())
 		return
 	}
 
+	var deadline time.Time
+	if timeLimit > 0 {
+		deadline = time.Now().Add(time.Duration(timeLimit) * time.Second)
+	}
+
 	// Make sure domain components in base query match the configuration
-	tval := testDomain(fmt.Sprintf("%v", p.Children[0].Value), config.Configuration.Domain)
+	tval := testDomain(baseDN, config.Configuration.Domain)
 	if tval > 0 {
 		if tval == 1 {
 			addEndOfSearchPkg(eosp, 10, "0000202B: RefErr: DSID-0310084A, data 0, 1 access points")
 		} else {
 			addEndOfSearchPkg(eosp, 32, "0000208D: NameErr: DSID-0310028C, problem 2001 (NO_OBJECT), data 0, best match of:")
 		}
-		conn.Write(eosp.Bytes())
+		sess.Write(eosp.Bytes())
 		return
 	}
 
-	// Create response
-	allObjectsRaw := joinGroupsAndUsers(config)
+	// Change notification search never completes, it only sends the objects which change
+	if findControl(op.Controls, notificationOID) != nil {
+		if findControl(op.Controls, pagedResultsOID) != nil || findControl(op.Controls, sortRequestOID) != nil || findControl(op.Controls, vlvRequestOID) != nil {
+			addEndOfSearchPkg(eosp, ResultUnwillingToPerform, controlErrorMessage)
+			sess.Write(eosp.Bytes())
+			return
+		}
+
+		listener := addChangeListener(sess, config, baseDN, scope, p.Children[6])
+		if listener == nil {
+			addEndOfSearchPkg(eosp, ResultAdminLimitExceeded, "00002024: SvcErr: DSID-031007DF, problem 5008 (ADMIN_LIMIT_EXCEEDED), data 0")
+			sess.Write(eosp.Bytes())
+			return
+		}
+
+		waitForChanges(sess, op, listener, parseEntryOptions(p, config.Configuration.MaxValRange))
+		if op.finish() == errCanceled {
+			addEndOfSearchPkg(eosp, ResultCanceled, "")
+			sess.Write(eosp.Bytes())
+		}
+		return
+	}
 
-	// IDX 6 contains possible filters
-	allObjects := filterObjects(allObjectsRaw, p.Children[6])
+	// DirSync returns changes since the previous synchronization, instead of current state
+	if control := findControl(op.Controls, dirSyncOID); control != nil {
+		request, err := parseDirSyncControl(control.Value)
+		if err != nil {
+			addEndOfSearchPkg(eosp, ResultProtocolError, controlErrorMessage)
+			sess.Write(eosp.Bytes())
+			return
+		}
 
-	// Finally return results
-	for _, object := range allObjects {
-		rspX := createResponsePacket(msgNum)
-		objectName := createObjectName(object.Cn, "CN=Users", config.Configuration.Domain)
-		attrPkg, sREPkg := createSearchResEntry(objectName, object.ObjectClass, object.Attributes)
+		if findControl(op.Controls, pagedResultsOID) != nil || findControl(op.Controls, sortRequestOID) != nil || findControl(op.Controls, vlvRequestOID) != nil {
+			addEndOfSearchPkg(eosp, ResultUnwillingToPerform, controlErrorMessage)
+			sess.Write(eosp.Bytes())
+			return
+		}
 
-		// Add CN
-		createAttributePkg(attrPkg, "cn", []string{object.Cn})
+		dirSyncSearch(sess, p, op, request, config)
+		return
+	}
 
-		// Add memberof packages
-		if len(object.MemberOf) > 0 {
-			createAttributePkg(attrPkg, "memberOf", object.MemberOf)
+	// Paged search continues from the results stored with the cookie
+	var paging *pagedResultsControl
+	var sortKeys []sortKey
+	var rspControls []Control
+	var allObjects []models.LdapElement
+	var limited bool
+
+	if control := findControl(op.Controls, pagedResultsOID); control != nil {
+		pagedControl, err := parsePagedResultsControl(control.Value)
+		if err != nil {
+			addEndOfSearchPkg(eosp, ResultProtocolError, controlErrorMessage)
+			sess.Write(eosp.Bytes())
+			return
 		}
+		paging = &pagedControl
+	}
 
-		if object.UserAccountControl > 0 {
-			uacStr := strconv.Itoa(object.UserAccountControl)
-			createAttributePkg(attrPkg, "userAccountControl", []string{uacStr})
+	sortControl := findControl(op.Controls, sortRequestOID)
+	if sortControl != nil {
+		var err error
+		if sortKeys, err = parseSortControl(sortControl.Value); err != nil {
+			addEndOfSearchPkg(eosp, ResultProtocolError, controlErrorMessage)
+			sess.Write(eosp.Bytes())
+			return
 		}
+	}
 
-		// Attach attributes to response, and finally send the response package
-		sREPkg.AppendChild(attrPkg)
-		rspX.AppendChild(sREPkg)
-		conn.Write(rspX.Bytes())
+	var vlv *vlvRequest
+	if control := findControl(op.Controls, vlvRequestOID); control != nil {
+		vlvControl, err := parseVLVControl(control.Value)
+		if err != nil {
+			addEndOfSearchPkg(eosp, ResultProtocolError, controlErrorMessage)
+			sess.Write(eosp.Bytes())
+			return
+		}
+		vlv = &vlvControl
 	}
 
-	/*
-		For time being these are not used:
+	// Virtual list view replaces paging, both can't be used at the same time
+	if vlv != nil && paging != nil {
+		addEndOfSearchPkg(eosp, ResultUnwillingToPerform, controlErrorMessage)
+		sess.Write(eosp.Bytes())
+		return
+	}
 
-		scope := p.Children[1].Value
-		// (2 = wholeSubtree)
-		derefAliases := p.Children[2].Value
-		// (0 = neverderefer)
-		sizeLimit := p.Children[3].Value
-		timeLimit := p.Children[4].Value
-		typesOnly := p.Children[5].Value
+	if paging != nil && paging.cookie != "" {
+		previous := sess.takePagedSearch(paging.cookie)
+		if previous == nil || previous.request != string(p.Bytes()) {
+			addEndOfSearchPkg(eosp, ResultUnwillingToPerform, "00002024: LdapErr: DSID-0C090D8F, comment: The paged results cookie is not valid, data 0, v4563")
+			sess.Write(eosp.Bytes())
+			return
+		}
+		allObjects = previous.results
+		limited = previous.limited
 
-	*/
+		// Results were already sorted for the first page
+		if sortControl != nil {
+			rspControls = append(rspControls, createSortResponseControl(ResultSuccess, ""))
+		}
+	} else {
+		// Create response from snapshot of the directory, so that it's not locked while sending results
+		// Configuration and schema naming contexts are separate from the domain
+		config.RLock()
+		allObjectsRaw, ok := namingContextElements(config, baseDN)
+		if !ok {
+			allObjectsRaw = append(containerElements(config), joinGroupsAndUsers(config)...)
+			if showDeleted(op.Controls) {
+				allObjectsRaw = append(allObjectsRaw, deletedObjects(config)...)
+			}
+		}
+
+		// Search base must exist, otherwise client is told the closest existing parent
+		if !slices.ContainsFunc(allObjectsRaw, func(object models.LdapElement) bool { return normalizeDN(object.DN) == normalizeDN(baseDN) }) {
+			result := noSuchObjectResult(config, baseDN)
+			config.RUnlock()
+
+			eosp.AppendChild(createResultPkg(0x05, result.statusCode, result.matchedDN, result.errorMessage))
+			sess.Write(eosp.Bytes())
+			return
+		}
+		config.RUnlock()
+
+		// IDX 6 contains possible filters
+		allObjects = filterObjects(objectsInScope(allObjectsRaw, baseDN, scope), p.Children[6])
+
+		// Unsorted results are fine, unless client insists on sorting
+		if sortControl != nil {
+			sortResult, attribute := sortObjects(allObjects, sortKeys)
+			rspControls = append(rspControls, createSortResponseControl(sortResult, attribute))
+
+			if sortResult != ResultSuccess && sortControl.Criticality {
+				addEndOfSearchPkg(eosp, ResultUnavailableCriticalExtension, unavailableExtensionMessage)
+				addControlsPkg(eosp, rspControls)
+				sess.Write(eosp.Bytes())
+				return
+			}
+		}
+
+		// Virtual list view returns only the window around target entry
+		if vlv != nil {
+			var vlvResult int
+			var vlvControl Control
+			allObjects, vlvResult, vlvControl = applyVirtualListView(allObjects, *vlv, sortKeys)
+			rspControls = append(rspControls, vlvControl)
+
+			if vlvResult != ResultSuccess {
+				addEndOfSearchPkg(eosp, vlvResult, controlErrorMessage)
+				addControlsPkg(eosp, rspControls)
+				sess.Write(eosp.Bytes())
+				return
+			}
+		}
+
+		// Client's size limit applies to the whole result, also when it's returned in pages
+		if sizeLimit > 0 && len(allObjects) > int(sizeLimit) {
+			allObjects = allObjects[:sizeLimit]
+			limited = true
+		}
+	}
+
+	options := parseEntryOptions(p, config.Configuration.MaxValRange)
+
+	// Server returns at most MaxPageSize entries at once. Page size 0 ends the paged search
+	// without returning anything.
+	maxPageSize := config.Configuration.MaxPageSize
+	var remaining []models.LdapElement
+	if paging != nil {
+		pageSize := paging.size
+		if maxPageSize > 0 && pageSize > maxPageSize {
+			pageSize = maxPageSize
+		}
+		if len(allObjects) > pageSize {
+			if pageSize > 0 {
+				remaining = allObjects[pageSize:]
+			}
+			allObjects = allObjects[:pageSize]
+		}
+	} else if maxPageSize > 0 && len(allObjects) > maxPageSize {
+		allObjects = allObjects[:maxPageSize]
+		limited = true
+	}
+
+	// Finally return results, unless client abandons or cancels the search
+	inTime := sendSearchEntries(sess, op, allObjects, options, deadline)
+
+	if err := op.finish(); err != nil {
+		// Abandoned search is never answered, canceled one tells the client it was canceled
+		if err == errCanceled {
+			addEndOfSearchPkg(eosp, ResultCanceled, "")
+			sess.Write(eosp.Bytes())
+		}
+		return
+	}
+
+	// Search which ran out of time or entries tells it with the partial result
+	switch {
+	case !inTime:
+		addEndOfSearchPkg(eosp, ResultTimeLimitExceeded, "")
+	case limited && len(remaining) == 0:
+		addEndOfSearchPkg(eosp, ResultSizeLimitExceeded, "")
+	default:
+		addEndOfSearchPkg(eosp, ResultSuccess, "")
+	}
+
+	// Rest of the results are kept for the next page, empty cookie tells that search is done
+	if paging != nil {
+		cookie := ""
+		if len(remaining) > 0 && inTime {
+			cookie = sess.storePagedSearch(&pagedSearch{request: string(p.Bytes()), msgNum: op.ID, results: remaining, limited: limited}, config.Configuration.MaxResultSetSize)
+		}
+		rspControls = append(rspControls, createPagedResultsControl(cookie))
+	}
+	addControlsPkg(eosp, rspControls)
 
-	addEndOfSearchPkg(eosp, 0, "")
-	conn.Write(eosp.Bytes())
+	sess.Write(eosp.Bytes())
 }
@@ -2,12 +2,15 @@ package ldap
 
 import (
 	"bytes"
+	"slices"
 	"testing"
+	"time"
 
 	"smad/internal/mocks"
 	"smad/models"
 
 	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/google/uuid"
 )
 
 func createTestData1() []models.LdapElement {
@@ -54,8 +57,8 @@ func createTestDataWithElements(elements ...models.LdapElement) []models.LdapEle
 }
 
 // Helper function to create a basic test configuration
-func createTestConfig(domain string) models.AppConfig {
-	return models.AppConfig{
+func createTestConfig(domain string) *models.AppConfig {
+	return &models.AppConfig{
 		Configuration: models.Configuration{
 			Domain: domain,
 		},
@@ -63,8 +66,8 @@ func createTestConfig(domain string) models.AppConfig {
 }
 
 // Helper function to create a test configuration with users and groups
-func createTestConfigWithUsersAndGroups(domain string, users []models.User, groups []models.Group) models.AppConfig {
-	return models.AppConfig{
+func createTestConfigWithUsersAndGroups(domain string, users []models.User, groups []models.Group) *models.AppConfig {
+	return &models.AppConfig{
 		Configuration: models.Configuration{
 			Domain: domain,
 		},
@@ -77,6 +80,7 @@ func createTestConfigWithUsersAndGroups(domain string, users []models.User, grou
 func createTestUser(cn, upn, password string, groups []string, attributes map[string]string) models.User {
 	return models.User{
 		Cn:         cn,
+		ObjectGUID: uuid.NewSHA1(uuid.NameSpaceURL, []byte(upn)).String(),
 		Upn:        upn,
 		Password:   password,
 		Groups:     groups,
@@ -92,13 +96,20 @@ func createTestGroup(cn string) models.Group {
 }
 
 // Helper function to create a mock connection and search request
-func createTestSetup(domain, baseDN, filter string, authenticated bool) (*mocks.MockConn, *ber.Packet, models.AppConfig) {
+func createTestSetup(domain, baseDN, filter string, authenticated bool) (*mocks.MockConn, *ber.Packet, *models.AppConfig) {
 	conn := mocks.NewMockConn()
 	config := createTestConfig(domain)
 	searchReq := createSearchRequestPacket(baseDN, filter)
 	return conn, searchReq, config
 }
 
+// Helper function to create a session for given connection and bind state
+func createTestSession(conn *mocks.MockConn, bindSuccessful bool) *Session {
+	sess := NewSession(conn)
+	sess.BindSuccessful = bindSuccessful
+	return sess
+}
+
 // Helper function to assert that a response was written
 func assertResponseWritten(t *testing.T, conn *mocks.MockConn, testName string) {
 	writtenData := conn.GetWrittenData()
@@ -529,7 +540,7 @@ func TestHandleSearchRequestUnauthenticated(t *testing.T) {
 	conn, searchReq, config := createTestSetup("example.com", "DC=example,DC=com", "", false)
 
 	// Test unauthenticated search request
-	HandleSearchRequest(conn, searchReq, 1, false, config)
+	HandleSearchRequest(createTestSession(conn, false), searchReq, NewOperation(1, 3), config)
 
 	// Verify that a response was written
 	assertResponseWritten(t, conn, "HandleSearchRequest for unauthenticated request")
@@ -538,12 +549,27 @@ func TestHandleSearchRequestUnauthenticated(t *testing.T) {
 	assertResponseContains(t, conn, "HandleSearchRequest for unauthenticated request", []byte("successful bind must be completed"))
 }
 
+func TestHandleSearchRequestMalformed(t *testing.T) {
+	config := createTestConfig("example.com")
+
+	// Search request without filter
+	searchReq := createSearchRequestPacket("DC=example,DC=com", "")
+	searchReq.Children = searchReq.Children[:6]
+
+	conn := mocks.NewMockConn()
+	HandleSearchRequest(createTestSession(conn, true), searchReq, NewOperation(1, 3), config)
+
+	if code := lastResultCode(t, conn); code != ResultProtocolError {
+		t.Errorf("search request without filter returned %d, want protocolError", code)
+	}
+}
+
 func TestHandleSearchRequestInvalidDomain(t *testing.T) {
 	// Create test setup
 	conn, searchReq, config := createTestSetup("example.com", "DC=wrong,DC=com", "", true)
 
 	// Test search request with different domain
-	HandleSearchRequest(conn, searchReq, 2, true, config)
+	HandleSearchRequest(createTestSession(conn, true), searchReq, NewOperation(2, 3), config)
 
 	// Verify that a response was written
 	assertResponseWritten(t, conn, "HandleSearchRequest for domain request")
@@ -571,7 +597,7 @@ func TestHandleSearchRequestSuccessful(t *testing.T) {
 	)
 
 	// Test successful search request
-	HandleSearchRequest(conn, searchReq, 3, true, config)
+	HandleSearchRequest(createTestSession(conn, true), searchReq, NewOperation(3, 3), config)
 
 	// Verify that a response was written
 	assertResponseWritten(t, conn, "HandleSearchRequest for successful request")
@@ -599,7 +625,7 @@ func TestHandleSearchRequestWithFilter(t *testing.T) {
 	)
 
 	// Test search request with filter
-	HandleSearchRequest(conn, searchReq, 4, true, config)
+	HandleSearchRequest(createTestSession(conn, true), searchReq, NewOperation(4, 3), config)
 
 	// Verify that a response was written
 	assertResponseWritten(t, conn, "HandleSearchRequest for filtered request")
@@ -621,7 +647,7 @@ func TestHandleSearchRequestWithFilter(t *testing.T) {
 
 func TestJoinGroupsAndUsers(t *testing.T) {
 	// Create test configuration
-	config := models.AppConfig{
+	config := &models.AppConfig{
 		Configuration: models.Configuration{
 			Domain: "example.com",
 		},
@@ -718,3 +744,108 @@ func TestCreateSearchResEntry(t *testing.T) {
 		t.Error("attrPkg should contain objectClass and custom attributes")
 	}
 }
+
+// Helper function to run search with given scope. Returns names of found objects, result
+// code and matched DN of the response.
+func searchScope(t *testing.T, config *models.AppConfig, baseDN string, scope int64) ([]string, int64, string) {
+	conn := mocks.NewMockConn()
+	searchReq := createSearchRequestPacket(baseDN, "")
+	searchReq.Children[1] = ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, scope, "")
+	HandleSearchRequest(createTestSession(conn, true), searchReq, NewOperation(1, 3), config)
+
+	var names []string
+	messages := decodeMessages(t, conn)
+	for _, msg := range messages[:len(messages)-1] {
+		names = append(names, msg.Children[1].Children[0].Value.(string))
+	}
+	done := messages[len(messages)-1].Children[1]
+	return names, done.Children[0].Value.(int64), done.Children[1].Value.(string)
+}
+
+func TestSearchScope(t *testing.T) {
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("user1", "user1@example.com", "pass", nil, nil),
+		createTestUser("user2", "user2@example.com", "pass", nil, nil),
+	}, nil)
+	config.OrganizationalUnits = []models.OrganizationalUnit{{Ou: "Staff"}}
+	config.Users[1].Container = "OU=Staff"
+
+	tests := []struct {
+		name     string
+		baseDN   string
+		scope    int64
+		expected []string
+	}{
+		{"base", "OU=Staff,DC=example,DC=com", scopeBaseObject, []string{"OU=Staff,DC=example,DC=com"}},
+		{"base of domain", "dc=example,dc=com", scopeBaseObject, []string{"DC=example,DC=com"}},
+		{"one level", "DC=example,DC=com", scopeSingleLevel, []string{"CN=Users,DC=example,DC=com", "OU=Staff,DC=example,DC=com"}},
+		{"one level of container", "CN=Users,DC=example,DC=com", scopeSingleLevel, []string{"CN=user1,CN=Users,DC=example,DC=com"}},
+		{"subtree", "OU=Staff,DC=example,DC=com", scopeWholeSubtree, []string{"OU=Staff,DC=example,DC=com", "CN=user2,OU=Staff,DC=example,DC=com"}},
+	}
+
+	for _, tt := range tests {
+		names, code, _ := searchScope(t, config, tt.baseDN, tt.scope)
+		if code != ResultSuccess || !slices.Equal(names, tt.expected) {
+			t.Errorf("%s: search returned %d and %v, want %v", tt.name, code, names, tt.expected)
+		}
+	}
+}
+
+func TestSearchMissingBase(t *testing.T) {
+	config := createTestConfig("example.com")
+	config.OrganizationalUnits = []models.OrganizationalUnit{{Ou: "Staff"}}
+
+	names, code, matchedDN := searchScope(t, config, "OU=Helsinki,OU=Staff,DC=example,DC=com", scopeWholeSubtree)
+	if code != ResultNoSuchObject || len(names) != 0 {
+		t.Fatalf("search returned %d and %v, want noSuchObject", code, names)
+	}
+	if matchedDN != "OU=Staff,DC=example,DC=com" {
+		t.Errorf("matched DN = %q, want closest existing parent", matchedDN)
+	}
+}
+
+func TestSearchSizeLimit(t *testing.T) {
+	config := createPagingTestConfig(5)
+	searchReq := createSearchRequestPacket("DC=example,DC=com", "(objectClass=person)")
+	searchReq.Children[3] = ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 2, "")
+
+	conn := mocks.NewMockConn()
+	HandleSearchRequest(createTestSession(conn, true), searchReq, NewOperation(1, 3), config)
+	if messages := decodeMessages(t, conn); len(messages) != 3 {
+		t.Errorf("search returned %d entries, want 2", len(messages)-1)
+	}
+	if code := lastResultCode(t, conn); code != ResultSizeLimitExceeded {
+		t.Errorf("search returned %d, want sizeLimitExceeded", code)
+	}
+
+	// Without paging server doesn't return more than MaxPageSize entries
+	config.Configuration.MaxPageSize = 4
+	conn = mocks.NewMockConn()
+	HandleSearchRequest(createTestSession(conn, true), createSearchRequestPacket("DC=example,DC=com", "(objectClass=person)"), NewOperation(1, 3), config)
+	if messages := decodeMessages(t, conn); len(messages) != 5 {
+		t.Errorf("search returned %d entries, want 4", len(messages)-1)
+	}
+	if code := lastResultCode(t, conn); code != ResultSizeLimitExceeded {
+		t.Errorf("search over MaxPageSize returned %d, want sizeLimitExceeded", code)
+	}
+}
+
+func TestSendSearchEntriesTimeLimit(t *testing.T) {
+	conn := mocks.NewMockConn()
+	objects := []models.LdapElement{createUserElement("user1"), createUserElement("user2")}
+
+	if !sendSearchEntries(createTestSession(conn, true), NewOperation(1, 3), objects, entryOptions{}, time.Time{}) {
+		t.Error("search without time limit should not run out of time")
+	}
+	if len(decodeMessages(t, conn)) != 2 {
+		t.Error("all entries should be sent")
+	}
+
+	conn = mocks.NewMockConn()
+	if sendSearchEntries(createTestSession(conn, true), NewOperation(1, 3), objects, entryOptions{}, time.Now().Add(-time.Second)) {
+		t.Error("search past its deadline should run out of time")
+	}
+	if len(decodeMessages(t, conn)) != 0 {
+		t.Error("no entries should be sent after deadline")
+	}
+}
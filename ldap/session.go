@@ -0,0 +1,153 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Session holds the state of a single client connection. Operations of the same connection
+// are processed concurrently, so writes to the client are serialized to keep every LDAP
+// message intact.
+type Session struct {
+	net.Conn
+	reader    *bufio.Reader
+	writeLock sync.Mutex
+	pending   sync.WaitGroup
+
+	opLock     sync.Mutex
+	operations map[int64]*Operation
+
+	pagingLock    sync.Mutex
+	pagedSearches []*pagedSearch
+
+	BindSuccessful bool
+	BindUser       string // objectGUID of the bound user, which stays same when user is renamed
+}
+
+func NewSession(conn net.Conn) *Session {
+	return &Session{Conn: conn, reader: bufio.NewReader(conn), operations: make(map[int64]*Operation)}
+}
+
+// ReadMessage reads next complete LDAP message sent by the client
+func (s *Session) ReadMessage(maxSize int) (*ber.Packet, error) {
+	return ReadMessage(s.reader, maxSize)
+}
+
+// WaitForMessage blocks until client sends something. Connection isn't idle while it has
+// outstanding operations (like change notification searches), so idle time is only
+// enforced when there are none.
+func (s *Session) WaitForMessage(idleTime time.Duration) error {
+	for {
+		s.Conn.SetReadDeadline(time.Now().Add(idleTime))
+		_, err := s.reader.Peek(1)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, os.ErrDeadlineExceeded) || s.outstandingOperations() == 0 {
+			return err
+		}
+	}
+}
+
+// Write sends one complete LDAP message to the client
+func (s *Session) Write(b []byte) (int, error) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	return s.Conn.Write(b)
+}
+
+// Close stops all outstanding operations and closes the connection
+func (s *Session) Close() error {
+	s.opLock.Lock()
+	for _, op := range s.operations {
+		op.stop(errAbandoned)
+	}
+	s.opLock.Unlock()
+
+	return s.Conn.Close()
+}
+
+// Run processes operation in its own goroutine, so that client can have multiple
+// outstanding operations on same connection. While running, the operation can be
+// found by its message id for abandon and cancel requests.
+func (s *Session) Run(op *Operation, operation func()) {
+	s.opLock.Lock()
+	s.operations[op.ID] = op
+	s.opLock.Unlock()
+
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		defer close(op.done)
+		defer s.removeOperation(op)
+		operation()
+	}()
+}
+
+// Wait blocks until all outstanding operations have completed
+func (s *Session) Wait() {
+	s.pending.Wait()
+}
+
+// WaitForBind blocks until outstanding operations have completed, so that bind can proceed.
+// Change notification searches never complete on their own, and they were authorized for the
+// previous identity, so they are abandoned instead.
+func (s *Session) WaitForBind() {
+	s.opLock.Lock()
+	for _, op := range s.operations {
+		if findControl(op.Controls, notificationOID) != nil {
+			op.stop(errAbandoned)
+		}
+	}
+	s.opLock.Unlock()
+
+	s.Wait()
+}
+
+// Is the connection already encrypted (either ldaps or StartTLS)?
+func (s *Session) isTLS() bool {
+	_, ok := s.Conn.(*tls.Conn)
+	return ok
+}
+
+func (s *Session) outstandingOperations() int {
+	s.opLock.Lock()
+	defer s.opLock.Unlock()
+	return len(s.operations)
+}
+
+// Upgrades plain connection to TLS in place. Must only be called from the goroutine reading
+// requests, while there are no outstanding operations.
+func (s *Session) startTLS(config *tls.Config) error {
+	tlsConn := tls.Server(s.Conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+
+	s.Conn = tlsConn
+	s.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+func (s *Session) findOperation(msgNum int64) *Operation {
+	s.opLock.Lock()
+	defer s.opLock.Unlock()
+	return s.operations[msgNum]
+}
+
+func (s *Session) removeOperation(op *Operation) {
+	s.opLock.Lock()
+	defer s.opLock.Unlock()
+
+	// Misbehaving client might reuse message id of an outstanding operation
+	if s.operations[op.ID] == op {
+		delete(s.operations, op.ID)
+	}
+}
@@ -0,0 +1,27 @@
+package ldap
+
+import (
+	"testing"
+
+	"smad/internal/mocks"
+)
+
+func TestSessionConcurrentWrites(t *testing.T) {
+	conn := mocks.NewMockConn()
+	sess := NewSession(conn)
+
+	// Many operations answering at the same time must not mix up their messages
+	for i := 1; i <= 50; i++ {
+		msgNum := int64(i)
+		sess.Run(NewOperation(msgNum, 3), func() {
+			rsp := createResponsePacket(msgNum)
+			addEndOfSearchPkg(rsp, ResultSuccess, "")
+			sess.Write(rsp.Bytes())
+		})
+	}
+	sess.Wait()
+
+	if count := len(decodeMessages(t, conn)); count != 50 {
+		t.Errorf("session wrote %d messages, want 50", count)
+	}
+}
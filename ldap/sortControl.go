@@ -0,0 +1,155 @@
+package ldap
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"smad/models"
+	"strconv"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Server side sort controls (RFC 2891)
+const (
+	sortRequestOID  = "1.2.840.113556.1.4.473"
+	sortResponseOID = "1.2.840.113556.1.4.474"
+)
+
+type sortKey struct {
+	attribute    string
+	orderingRule string
+	reverse      bool
+}
+
+// Multi-valued attributes can't be used for sorting, same as in AD
+var unsortableAttributes = []string{"objectClass", "memberOf", "member"}
+
+// Attributes which are compared as integers, when ordering rule is not given
+var integerAttributes = []string{"userAccountControl"}
+
+// Supported ordering rules, by matching rule OID
+var orderingRules = map[string]func(a, b string) int{
+	"2.5.13.3":  func(a, b string) int { return strings.Compare(strings.ToLower(a), strings.ToLower(b)) }, // caseIgnoreOrderingMatch
+	"2.5.13.5":  strings.Compare,                                                                          // caseExactOrderingMatch
+	"2.5.13.15": compareIntegers,                                                                          // integerOrderingMatch
+}
+
+func compareIntegers(a, b string) int {
+	aInt, aErr := strconv.ParseInt(a, 10, 64)
+	bInt, bErr := strconv.ParseInt(b, 10, 64)
+	if aErr != nil || bErr != nil {
+		return strings.Compare(a, b)
+	}
+	return cmp.Compare(aInt, bInt)
+}
+
+// Parses value of sort request control, which is a list of sort keys
+func parseSortControl(value []byte) ([]sortKey, error) {
+	valuePacket, err := ber.DecodePacketErr(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(valuePacket.Children) == 0 {
+		return nil, errors.New("no sort keys")
+	}
+
+	var keys []sortKey
+	for _, keyPacket := range valuePacket.Children {
+		if len(keyPacket.Children) == 0 || keyPacket.Children[0].Tag != ber.TagOctetString {
+			return nil, errors.New("invalid sort key")
+		}
+
+		key := sortKey{attribute: keyPacket.Children[0].Data.String()}
+
+		// Ordering rule [0] and reverse order [1] are both optional
+		for _, child := range keyPacket.Children[1:] {
+			switch {
+			case child.ClassType == ber.ClassContext && child.Tag == 0:
+				key.orderingRule = child.Data.String()
+			case child.ClassType == ber.ClassContext && child.Tag == 1:
+				key.reverse = len(child.Data.Bytes()) == 1 && child.Data.Bytes()[0] != 0
+			default:
+				return nil, errors.New("invalid sort key")
+			}
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func createSortResponseControl(sortResult int, attribute string) Control {
+	value := ber.NewSequence("")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, sortResult, ""))
+	if attribute != "" {
+		value.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, attribute, ""))
+	}
+	return Control{Type: sortResponseOID, Value: value.Bytes()}
+}
+
+// Returns function comparing values of the sort key, or result code telling why the key can't
+// be used for sorting
+func sortKeyCompareFunc(key sortKey) (func(a, b string) int, int) {
+	if slices.ContainsFunc(unsortableAttributes, func(attr string) bool { return strings.EqualFold(attr, key.attribute) }) {
+		return nil, ResultUnwillingToPerform
+	}
+
+	if key.orderingRule != "" {
+		compareFunc, ok := orderingRules[key.orderingRule]
+		if !ok {
+			return nil, ResultInappropriateMatching
+		}
+		return compareFunc, ResultSuccess
+	}
+
+	if slices.ContainsFunc(integerAttributes, func(attr string) bool { return strings.EqualFold(attr, key.attribute) }) {
+		return compareIntegers, ResultSuccess
+	}
+	return orderingRules["2.5.13.3"], ResultSuccess
+}
+
+// Sorts objects in place by given keys. If some key can't be used, objects are left unsorted and
+// result code and the offending attribute are returned.
+func sortObjects(objects []models.LdapElement, keys []sortKey) (int, string) {
+	var compareFuncs []func(a, b string) int
+
+	for _, key := range keys {
+		compareFunc, result := sortKeyCompareFunc(key)
+		if result != ResultSuccess {
+			return result, key.attribute
+		}
+		compareFuncs = append(compareFuncs, compareFunc)
+	}
+
+	slices.SortStableFunc(objects, func(a, b models.LdapElement) int {
+		for idx, key := range keys {
+			result := compareValues(attributeValues(a, key.attribute), attributeValues(b, key.attribute), compareFuncs[idx])
+			if key.reverse {
+				result = -result
+			}
+			if result != 0 {
+				return result
+			}
+		}
+		return 0
+	})
+
+	return ResultSuccess, ""
+}
+
+// Entries without the attribute are sorted after all others (RFC 2891, section 1.1)
+func compareValues(a, b []string, compareFunc func(a, b string) int) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+	return compareFunc(a[0], b[0])
+}
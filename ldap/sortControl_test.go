@@ -0,0 +1,132 @@
+package ldap
+
+import (
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to create sort request control with given keys
+func createSortRequest(critical bool, keys ...sortKey) Control {
+	value := ber.NewSequence("")
+	for _, key := range keys {
+		keyPacket := ber.NewSequence("")
+		keyPacket.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, key.attribute, ""))
+		if key.orderingRule != "" {
+			keyPacket.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, key.orderingRule, ""))
+		}
+		if key.reverse {
+			keyPacket.AppendChild(ber.NewBoolean(ber.ClassContext, ber.TypePrimitive, 1, true, ""))
+		}
+		value.AppendChild(keyPacket)
+	}
+	return Control{Type: sortRequestOID, Criticality: critical, Value: value.Bytes()}
+}
+
+// Helper function to run sorted search. Returns names of returned entries, result code and
+// sort result of the response control.
+func sortedSearch(t *testing.T, control Control, config *models.AppConfig) ([]string, int64, int64) {
+	conn := mocks.NewMockConn()
+	op := NewOperation(1, 3)
+	op.Controls = []Control{control}
+	HandleSearchRequest(createTestSession(conn, true), createSearchRequestPacket("DC=example,DC=com", "(objectClass=person)"), op, config)
+
+	messages := decodeMessages(t, conn)
+	var names []string
+	for _, msg := range messages[:len(messages)-1] {
+		names = append(names, msg.Children[1].Children[0].Value.(string))
+	}
+
+	done := messages[len(messages)-1]
+	controls, err := parseControls(done)
+	if err != nil || len(controls) != 1 || controls[0].Type != sortResponseOID {
+		t.Fatalf("search should return sort response control, got %v", controls)
+	}
+	value := ber.DecodePacket(controls[0].Value)
+
+	return names, done.Children[1].Children[0].Value.(int64), value.Children[0].Value.(int64)
+}
+
+func createSortTestConfig() *models.AppConfig {
+	return createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("bob", "bob@example.com", "pass", nil, map[string]string{"sn": "Builder", "displayName": "Bob"}),
+		createTestUser("alice", "alice@example.com", "pass", nil, map[string]string{"sn": "Cooper", "displayName": "alice"}),
+		createTestUser("carol", "carol@example.com", "pass", nil, map[string]string{"displayName": "Carol"}),
+	}, nil)
+}
+
+func TestSortedSearch(t *testing.T) {
+	config := createSortTestConfig()
+
+	names, resultCode, sortResult := sortedSearch(t, createSortRequest(true, sortKey{attribute: "displayName"}), config)
+	if resultCode != ResultSuccess || sortResult != ResultSuccess {
+		t.Fatalf("sorted search = result %d, sort result %d, want success", resultCode, sortResult)
+	}
+
+	expected := []string{"CN=alice,CN=Users,DC=example,DC=com", "CN=bob,CN=Users,DC=example,DC=com", "CN=carol,CN=Users,DC=example,DC=com"}
+	for idx, name := range expected {
+		if idx >= len(names) || names[idx] != name {
+			t.Fatalf("sorted search returned %v, want %v", names, expected)
+		}
+	}
+}
+
+func TestSortedSearchReverseMissingValues(t *testing.T) {
+	config := createSortTestConfig()
+
+	// Entries without the attribute are larger than everything else
+	names, _, _ := sortedSearch(t, createSortRequest(true, sortKey{attribute: "SN"}), config)
+	if len(names) != 3 || names[2] != "CN=carol,CN=Users,DC=example,DC=com" {
+		t.Errorf("entry without sort attribute should be last, got %v", names)
+	}
+
+	names, _, _ = sortedSearch(t, createSortRequest(true, sortKey{attribute: "sn", reverse: true}), config)
+	expected := []string{"CN=carol,CN=Users,DC=example,DC=com", "CN=alice,CN=Users,DC=example,DC=com", "CN=bob,CN=Users,DC=example,DC=com"}
+	for idx, name := range expected {
+		if idx >= len(names) || names[idx] != name {
+			t.Fatalf("reverse sorted search returned %v, want %v", names, expected)
+		}
+	}
+}
+
+func TestSortedSearchUnsortableAttribute(t *testing.T) {
+	config := createSortTestConfig()
+
+	names, resultCode, sortResult := sortedSearch(t, createSortRequest(true, sortKey{attribute: "memberOf"}), config)
+	if len(names) != 0 || resultCode != ResultUnavailableCriticalExtension || sortResult != ResultUnwillingToPerform {
+		t.Errorf("critical sort by memberOf = %d entries, result %d, sort result %d", len(names), resultCode, sortResult)
+	}
+
+	// Non-critical sort falls back to unsorted results
+	names, resultCode, sortResult = sortedSearch(t, createSortRequest(false, sortKey{attribute: "memberOf"}), config)
+	if len(names) != 3 || resultCode != ResultSuccess || sortResult != ResultUnwillingToPerform {
+		t.Errorf("non-critical sort by memberOf = %d entries, result %d, sort result %d", len(names), resultCode, sortResult)
+	}
+}
+
+func TestSortedSearchUnknownOrderingRule(t *testing.T) {
+	config := createSortTestConfig()
+
+	_, _, sortResult := sortedSearch(t, createSortRequest(false, sortKey{attribute: "sn", orderingRule: "1.2.3.4"}), config)
+	if sortResult != ResultInappropriateMatching {
+		t.Errorf("unknown ordering rule should give inappropriateMatching, got %d", sortResult)
+	}
+}
+
+func TestSortObjectsIntegerAttribute(t *testing.T) {
+	objects := []models.LdapElement{
+		{Cn: "a", UserAccountControl: 66048},
+		{Cn: "b", UserAccountControl: 514},
+		{Cn: "c", UserAccountControl: 512},
+	}
+
+	if result, _ := sortObjects(objects, []sortKey{{attribute: "userAccountControl"}}); result != ResultSuccess {
+		t.Fatalf("sortObjects() = %d, want success", result)
+	}
+	if objects[0].Cn != "c" || objects[1].Cn != "b" || objects[2].Cn != "a" {
+		t.Errorf("userAccountControl should be sorted numerically, got %v", objects)
+	}
+}
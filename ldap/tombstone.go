@@ -0,0 +1,84 @@
+package ldap
+
+import (
+	"slices"
+	"smad/models"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Controls which make deleted objects visible in searches
+const (
+	showDeletedOID  = "1.2.840.113556.1.4.417"
+	showRecycledOID = "1.2.840.113556.1.4.2064"
+)
+
+// Encodes GUID in the binary form used by AD, where first three fields are little endian
+func encodeGUID(guid string) string {
+	parsed, err := uuid.Parse(guid)
+	if err != nil {
+		return ""
+	}
+
+	slices.Reverse(parsed[0:4])
+	slices.Reverse(parsed[4:6])
+	slices.Reverse(parsed[6:8])
+	return string(parsed[:])
+}
+
+func deletedObjectsDN(domain string) string {
+	return "CN=Deleted Objects," + domainDN(domain)
+}
+
+// Deleted objects are visible only when client asks for them
+func showDeleted(controls []Control) bool {
+	return findControl(controls, showDeletedOID) != nil || findControl(controls, showRecycledOID) != nil
+}
+
+// Deleted Objects container and tombstones in it
+func deletedObjects(config *models.AppConfig) []models.LdapElement {
+	container := models.LdapElement{
+		DN:                 deletedObjectsDN(config.Configuration.Domain),
+		Cn:                 "Deleted Objects",
+		ObjectClass:        []string{"top", "container"},
+		Attributes:         map[string]string{"name": "Deleted Objects", "isDeleted": "TRUE", "isCriticalSystemObject": "TRUE"},
+		UserAccountControl: -1,
+	}
+
+	return append([]models.LdapElement{container}, config.Tombstones...)
+}
+
+// Keeps deleted object as a tombstone under Deleted Objects container. Name of the tombstone is
+// mangled with object's GUID, so that objects with same name can be deleted. Only few of the
+// attributes are kept, like in AD. Directory must be locked for writing.
+func createTombstone(config *models.AppConfig, object models.LdapElement) models.LdapElement {
+	rdnAttribute, rdnValue, parentDN := splitDN(object.DN)
+	name := rdnValue + "\nDEL:" + object.ObjectGUID
+
+	tombstone := models.LdapElement{
+		DN:                 rdnAttribute + "=" + escapeRDNValue(name) + "," + deletedObjectsDN(config.Configuration.Domain),
+		ObjectGUID:         object.ObjectGUID,
+		ObjectClass:        object.ObjectClass,
+		Attributes:         map[string]string{"name": name, "isDeleted": "TRUE", "lastKnownParent": parentDN},
+		UserAccountControl: -1,
+		USN:                models.USN{USNCreated: object.USNCreated},
+	}
+
+	// OUs are named by ou attribute
+	if object.Cn != "" {
+		tombstone.Cn = name
+	} else {
+		tombstone.Attributes[strings.ToLower(rdnAttribute)] = name
+	}
+
+	if samAccountName, ok := object.Attributes["sAMAccountName"]; ok {
+		tombstone.Attributes["sAMAccountName"] = samAccountName
+	}
+
+	markChanged(config, &tombstone.USN, "name", "isDeleted", "lastKnownParent")
+	config.Tombstones = append(config.Tombstones, tombstone)
+	notifyChange(config, tombstone)
+
+	return tombstone
+}
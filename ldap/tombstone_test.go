@@ -0,0 +1,103 @@
+package ldap
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+)
+
+// Helper function to search all objects with given controls, returns names of found entries
+func searchNames(t *testing.T, config *models.AppConfig, controls ...Control) []string {
+	conn := mocks.NewMockConn()
+	op := NewOperation(1, 3)
+	op.Controls = controls
+	HandleSearchRequest(createTestSession(conn, true), createSearchRequestPacket("DC=example,DC=com", ""), op, config)
+
+	var names []string
+	for _, msg := range decodeMessages(t, conn) {
+		if msg.Children[1].Tag == 0x04 {
+			names = append(names, msg.Children[1].Children[0].Value.(string))
+		}
+	}
+	return names
+}
+
+func TestCreateTombstone(t *testing.T) {
+	config := createTestConfigWithUsersAndGroups("example.com", []models.User{
+		createTestUser("testuser", "testuser@example.com", "pass", nil, map[string]string{"sAMAccountName": "testuser", "givenName": "Test"}),
+	}, nil)
+	config.Users[0].ObjectGUID = "0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f"
+	config.Users[0].USN = models.USN{USNCreated: 1, USNChanged: 1}
+	config.HighestUSN = 1
+
+	tombstone := createTombstone(config, userElement(config, config.Users[0]))
+	config.Users = nil
+
+	if tombstone.DN != "CN=testuser\\0ADEL:0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f,CN=Deleted Objects,DC=example,DC=com" {
+		t.Errorf("tombstone has wrong DN %s", tombstone.DN)
+	}
+	if tombstone.Attributes["isDeleted"] != "TRUE" || tombstone.Attributes["lastKnownParent"] != "CN=Users,DC=example,DC=com" {
+		t.Errorf("tombstone has wrong attributes %v", tombstone.Attributes)
+	}
+	if _, ok := tombstone.Attributes["givenName"]; ok || tombstone.Attributes["sAMAccountName"] != "testuser" {
+		t.Errorf("tombstone should keep only some attributes, got %v", tombstone.Attributes)
+	}
+	if tombstone.USNChanged != 2 || config.HighestUSN != 2 {
+		t.Error("tombstone creation should be recorded as a change")
+	}
+
+	// Deleted objects are not visible without controls
+	if names := searchNames(t, config); len(names) != 2 {
+		t.Errorf("search without show deleted returned %v", names)
+	}
+
+	for _, controlType := range []string{showDeletedOID, showRecycledOID} {
+		names := searchNames(t, config, Control{Type: controlType, Criticality: true})
+		if len(names) != 4 || names[2] != "CN=Deleted Objects,DC=example,DC=com" || names[3] != tombstone.DN {
+			t.Errorf("search with %s returned %v", controlType, names)
+		}
+	}
+}
+
+func TestDeleteCreatesTombstone(t *testing.T) {
+	config := createDeleteTestConfig()
+	config.OrganizationalUnits = []models.OrganizationalUnit{{Ou: "Staff", ObjectGUID: "2b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f"}}
+
+	for _, dn := range []string{"CN=user1,CN=Users,DC=example,DC=com", "CN=group1,CN=Users,DC=example,DC=com", "OU=Staff,DC=example,DC=com"} {
+		if code := deleteEntry(t, config, true, dn); code != ResultSuccess {
+			t.Fatalf("deleting %s returned %d", dn, code)
+		}
+	}
+
+	names := searchNames(t, config, Control{Type: showDeletedOID, Criticality: true})
+	for _, dn := range []string{
+		"CN=user1\\0ADEL:0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f,CN=Deleted Objects,DC=example,DC=com",
+		"CN=group1\\0ADEL:1b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f,CN=Deleted Objects,DC=example,DC=com",
+		"OU=Staff\\0ADEL:2b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f,CN=Deleted Objects,DC=example,DC=com",
+	} {
+		if !slices.Contains(names, dn) {
+			t.Errorf("search with show deleted should find tombstone %s, got %v", dn, names)
+		}
+	}
+
+	for _, name := range searchNames(t, config) {
+		if strings.Contains(name, "Deleted Objects") {
+			t.Errorf("search without show deleted returned %s", name)
+		}
+	}
+}
+
+func TestEncodeGUID(t *testing.T) {
+	expected := []byte{0x1c, 0x2d, 0x5f, 0x0b, 0x56, 0x4c, 0x1e, 0x4d, 0x9d, 0x2e, 0x0a, 0x3c, 0x1b, 0x2d, 0x3e, 0x4f}
+	if encoded := encodeGUID("0b5f2d1c-4c56-4d1e-9d2e-0a3c1b2d3e4f"); !bytes.Equal([]byte(encoded), expected) {
+		t.Errorf("encodeGUID() = %x, want %x", encoded, expected)
+	}
+
+	if encoded := encodeGUID("invalid"); encoded != "" {
+		t.Errorf("encodeGUID() should return empty string for invalid GUID, got %x", encoded)
+	}
+}
@@ -0,0 +1,142 @@
+package ldap
+
+import (
+	"errors"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Virtual list view controls (draft-ietf-ldapext-ldapv3-vlv-09)
+const (
+	vlvRequestOID  = "2.16.840.1.113730.3.4.9"
+	vlvResponseOID = "2.16.840.1.113730.3.4.10"
+)
+
+type vlvRequest struct {
+	beforeCount  int
+	afterCount   int
+	offset       int
+	contentCount int
+	// Target is the first entry which sorts at or after this value, instead of the offset
+	greaterThanOrEqual *string
+	contextID          []byte
+}
+
+func parseVLVControl(value []byte) (vlvRequest, error) {
+	var request vlvRequest
+
+	valuePacket, err := ber.DecodePacketErr(value)
+	if err != nil {
+		return request, err
+	}
+
+	if len(valuePacket.Children) < 3 {
+		return request, errors.New("invalid virtual list view control")
+	}
+
+	beforeCount, ok1 := valuePacket.Children[0].Value.(int64)
+	afterCount, ok2 := valuePacket.Children[1].Value.(int64)
+	if !ok1 || !ok2 || beforeCount < 0 || afterCount < 0 {
+		return request, errors.New("invalid virtual list view control")
+	}
+	request.beforeCount = int(beforeCount)
+	request.afterCount = int(afterCount)
+
+	target := valuePacket.Children[2]
+	switch {
+	case target.ClassType == ber.ClassContext && target.Tag == 0 && len(target.Children) == 2:
+		offset, err1 := ber.ParseInt64(target.Children[0].Data.Bytes())
+		contentCount, err2 := ber.ParseInt64(target.Children[1].Data.Bytes())
+		if err1 != nil || err2 != nil || offset < 0 || contentCount < 0 {
+			return request, errors.New("invalid virtual list view offset")
+		}
+		request.offset = int(offset)
+		request.contentCount = int(contentCount)
+	case target.ClassType == ber.ClassContext && target.Tag == 1:
+		assertionValue := target.Data.String()
+		request.greaterThanOrEqual = &assertionValue
+	default:
+		return request, errors.New("invalid virtual list view target")
+	}
+
+	if len(valuePacket.Children) > 3 {
+		request.contextID = valuePacket.Children[3].Data.Bytes()
+	}
+
+	return request, nil
+}
+
+func createVLVResponseControl(targetPosition, contentCount, vlvResult int, contextID []byte) Control {
+	value := ber.NewSequence("")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, targetPosition, ""))
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, contentCount, ""))
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, vlvResult, ""))
+	if contextID != nil {
+		value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(contextID), ""))
+	}
+	return Control{Type: vlvResponseOID, Value: value.Bytes()}
+}
+
+// Position of the target entry (1-based) in sorted objects. Offset given by client is scaled
+// from its estimate of the content count to the real one.
+func vlvTargetPosition(objects []models.LdapElement, request vlvRequest, keys []sortKey) int {
+	count := len(objects)
+
+	if request.greaterThanOrEqual != nil {
+		// Target is past the last entry, if nothing sorts at or after the assertion value
+		key := keys[0]
+		compareFunc, _ := sortKeyCompareFunc(key)
+		for idx, object := range objects {
+			result := compareValues(attributeValues(object, key.attribute), []string{*request.greaterThanOrEqual}, compareFunc)
+			if key.reverse {
+				result = -result
+			}
+			if result >= 0 {
+				return idx + 1
+			}
+		}
+		return count + 1
+	}
+
+	position := request.offset
+	if request.contentCount > 0 && request.offset != request.contentCount {
+		position = (request.offset*count + request.contentCount/2) / request.contentCount
+	} else if request.contentCount > 0 {
+		position = count
+	}
+
+	return max(1, min(position, count))
+}
+
+// Picks the window of entries around the target, and the response control telling the
+// position of target and size of the whole list
+func applyVirtualListView(objects []models.LdapElement, request vlvRequest, keys []sortKey) ([]models.LdapElement, int, Control) {
+	count := len(objects)
+
+	if len(keys) == 0 {
+		return nil, ResultSortControlMissing, createVLVResponseControl(0, count, ResultSortControlMissing, request.contextID)
+	}
+
+	// List view can't be built on unsorted results
+	for _, key := range keys {
+		if _, result := sortKeyCompareFunc(key); result != ResultSuccess {
+			return nil, ResultUnwillingToPerform, createVLVResponseControl(0, count, ResultUnwillingToPerform, request.contextID)
+		}
+	}
+
+	// Offset 0 is only valid, when client doesn't know the content count either
+	if request.greaterThanOrEqual == nil && request.offset == 0 && request.contentCount != 0 {
+		return nil, ResultOffsetRangeError, createVLVResponseControl(0, count, ResultOffsetRangeError, request.contextID)
+	}
+
+	target := vlvTargetPosition(objects, request, keys)
+
+	start := max(0, target-1-request.beforeCount)
+	end := min(count, target+request.afterCount)
+	if start > end {
+		start = end
+	}
+
+	return objects[start:end], ResultSuccess, createVLVResponseControl(target, count, ResultSuccess, request.contextID)
+}
@@ -0,0 +1,138 @@
+package ldap
+
+import (
+	"fmt"
+	"testing"
+
+	"smad/internal/mocks"
+	"smad/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Helper function to create offset based virtual list view request control
+func createVLVOffsetRequest(before, after, offset, contentCount int64) Control {
+	value := ber.NewSequence("")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, before, ""))
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, after, ""))
+	target := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "")
+	target.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, offset, ""))
+	target.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, contentCount, ""))
+	value.AppendChild(target)
+	return Control{Type: vlvRequestOID, Value: value.Bytes()}
+}
+
+// Helper function to create assertion value based virtual list view request control
+func createVLVValueRequest(before, after int64, assertionValue string) Control {
+	value := ber.NewSequence("")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, before, ""))
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, after, ""))
+	value.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 1, assertionValue, ""))
+	return Control{Type: vlvRequestOID, Value: value.Bytes()}
+}
+
+// Helper function to run virtual list view search. Returns names of returned entries, result
+// code, and target position, content count and result of the response control.
+func vlvSearch(t *testing.T, config *models.AppConfig, controls ...Control) ([]string, int64, []int64) {
+	conn := mocks.NewMockConn()
+	op := NewOperation(1, 3)
+	op.Controls = controls
+	HandleSearchRequest(createTestSession(conn, true), createSearchRequestPacket("DC=example,DC=com", "(objectClass=person)"), op, config)
+
+	messages := decodeMessages(t, conn)
+	var names []string
+	for _, msg := range messages[:len(messages)-1] {
+		names = append(names, msg.Children[1].Children[0].Value.(string))
+	}
+
+	done := messages[len(messages)-1]
+	resultCode := done.Children[1].Children[0].Value.(int64)
+
+	var vlvResponse []int64
+	responseControls, _ := parseControls(done)
+	if control := findControl(responseControls, vlvResponseOID); control != nil {
+		for _, child := range ber.DecodePacket(control.Value).Children[:3] {
+			vlvResponse = append(vlvResponse, child.Value.(int64))
+		}
+	}
+
+	return names, resultCode, vlvResponse
+}
+
+// Users user0000..user1999, so that cn order matches the numbering
+func createVLVTestConfig() *models.AppConfig {
+	var users []models.User
+	for i := 1999; i >= 0; i-- {
+		cn := fmt.Sprintf("user%04d", i)
+		users = append(users, createTestUser(cn, cn+"@example.com", "pass", nil, map[string]string{}))
+	}
+	return createTestConfigWithUsersAndGroups("example.com", users, nil)
+}
+
+func TestVLVByOffset(t *testing.T) {
+	config := createVLVTestConfig()
+
+	names, resultCode, vlvResponse := vlvSearch(t, config, createSortRequest(true, sortKey{attribute: "cn"}), createVLVOffsetRequest(2, 3, 101, 0))
+	if resultCode != ResultSuccess || len(vlvResponse) != 3 {
+		t.Fatalf("VLV search = result %d, response %v", resultCode, vlvResponse)
+	}
+	if vlvResponse[0] != 101 || vlvResponse[1] != 2000 || vlvResponse[2] != ResultSuccess {
+		t.Errorf("VLV response = %v, want target 101 and content count 2000", vlvResponse)
+	}
+	if len(names) != 6 || names[0] != "CN=user0098,CN=Users,DC=example,DC=com" || names[5] != "CN=user0103,CN=Users,DC=example,DC=com" {
+		t.Errorf("VLV search returned %v, want user0098..user0103", names)
+	}
+}
+
+func TestVLVByOffsetScaled(t *testing.T) {
+	config := createVLVTestConfig()
+
+	// Client thinks there are 100 entries, so offset 50 is the middle of the list
+	names, _, vlvResponse := vlvSearch(t, config, createSortRequest(true, sortKey{attribute: "cn"}), createVLVOffsetRequest(0, 0, 50, 100))
+	if len(vlvResponse) != 3 || vlvResponse[0] != 1000 || len(names) != 1 || names[0] != "CN=user0999,CN=Users,DC=example,DC=com" {
+		t.Errorf("scaled VLV search returned %v, response %v", names, vlvResponse)
+	}
+
+	// Offset equal to content count is always the last entry
+	names, _, vlvResponse = vlvSearch(t, config, createSortRequest(true, sortKey{attribute: "cn"}), createVLVOffsetRequest(1, 5, 7, 7))
+	if len(vlvResponse) != 3 || vlvResponse[0] != 2000 || len(names) != 2 {
+		t.Errorf("VLV search for last entry returned %v, response %v", names, vlvResponse)
+	}
+}
+
+func TestVLVGreaterThanOrEqual(t *testing.T) {
+	config := createVLVTestConfig()
+
+	names, _, vlvResponse := vlvSearch(t, config, createSortRequest(true, sortKey{attribute: "cn"}), createVLVValueRequest(1, 1, "USER1500"))
+	if len(vlvResponse) != 3 || vlvResponse[0] != 1501 {
+		t.Fatalf("VLV response = %v, want target 1501", vlvResponse)
+	}
+	if len(names) != 3 || names[1] != "CN=user1500,CN=Users,DC=example,DC=com" {
+		t.Errorf("VLV search returned %v, want user1499..user1501", names)
+	}
+
+	// Nothing sorts after the value, so target is past the last entry
+	names, _, vlvResponse = vlvSearch(t, config, createSortRequest(true, sortKey{attribute: "cn"}), createVLVValueRequest(2, 2, "zzz"))
+	if len(vlvResponse) != 3 || vlvResponse[0] != 2001 || len(names) != 2 {
+		t.Errorf("VLV search past the end returned %v, response %v", names, vlvResponse)
+	}
+}
+
+func TestVLVErrors(t *testing.T) {
+	config := createVLVTestConfig()
+
+	names, resultCode, vlvResponse := vlvSearch(t, config, createVLVOffsetRequest(0, 10, 1, 0))
+	if len(names) != 0 || resultCode != ResultSortControlMissing || len(vlvResponse) != 3 || vlvResponse[2] != ResultSortControlMissing {
+		t.Errorf("VLV without sort = %d entries, result %d, response %v", len(names), resultCode, vlvResponse)
+	}
+
+	names, resultCode, _ = vlvSearch(t, config, createSortRequest(true, sortKey{attribute: "cn"}), createVLVOffsetRequest(0, 10, 0, 10))
+	if len(names) != 0 || resultCode != ResultOffsetRangeError {
+		t.Errorf("VLV with offset 0 = %d entries, result %d, want offsetRangeError", len(names), resultCode)
+	}
+
+	_, resultCode, _ = vlvSearch(t, config, createSortRequest(true, sortKey{attribute: "cn"}), createVLVOffsetRequest(0, 10, 1, 0), createPagedResultsRequest(10, ""))
+	if resultCode != ResultUnwillingToPerform {
+		t.Errorf("VLV with paged results = result %d, want unwillingToPerform", resultCode)
+	}
+}
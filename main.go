@@ -15,15 +15,18 @@ func main() {
 	var err error
 	var listener net.Listener
 
-	if appConfig.Configuration.UseSSL {
+	if appConfig.Configuration.UseSSL || appConfig.Configuration.StartTLS {
 		var cert tls.Certificate
 		cert, err = tls.LoadX509KeyPair(appConfig.Configuration.CrtFile, appConfig.Configuration.KeyFile)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		config := &tls.Config{Certificates: []tls.Certificate{cert}}
-		listener, err = tls.Listen("tcp", port, config)
+		appConfig.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	if appConfig.Configuration.UseSSL {
+		listener, err = tls.Listen("tcp", port, appConfig.TLSConfig)
 	} else {
 		listener, err = net.Listen("tcp", port)
 	}
@@ -37,6 +40,8 @@ func main() {
 	connType := "connections"
 	if appConfig.Configuration.UseSSL {
 		connType = "SSL-connections"
+	} else if appConfig.Configuration.StartTLS {
+		connType = "connections (with StartTLS)"
 	}
 	log.Printf("Listening for %s on port %d\n", connType, appConfig.Configuration.Port)
 	log.Printf("Database contains %d user(s) and %d group(s)\n", len(appConfig.Users), len(appConfig.Groups))
@@ -1,13 +1,24 @@
 package models
 
+import (
+	"crypto/tls"
+	"sync"
+)
+
 type Configuration struct {
-	UseSSL    bool
-	Port      int    `json:"port"`
-	CrtFile   string `json:"crtFile"`
-	KeyFile   string `json:"keyFile"`
-	UserFile  string `json:"userFile"`
-	GroupFile string `json:"groupFile"`
-	Domain    string `json:"domain"`
+	UseSSL           bool
+	Port             int    `json:"port"`
+	CrtFile          string `json:"crtFile"`
+	KeyFile          string `json:"keyFile"`
+	UserFile         string `json:"userFile"`
+	GroupFile        string `json:"groupFile"`
+	Domain           string `json:"domain"`
+	StartTLS         bool   `json:"startTLS"`
+	MaxReceiveBuffer int    `json:"maxReceiveBuffer"`
+	MaxValRange      int    `json:"maxValRange"`
+	MaxPageSize      int    `json:"maxPageSize"`
+	MaxResultSetSize int    `json:"maxResultSetSize"`
+	MaxConnIdleTime  int    `json:"maxConnIdleTime"`
 }
 
 type LdapFilter struct {
@@ -16,11 +27,24 @@ type LdapFilter struct {
 }
 
 type LdapElement struct {
+	DN                 string
 	Cn                 string
+	ObjectGUID         string
 	Attributes         map[string]string
 	MemberOf           []string
+	Member             []string
+	MultiValued        map[string][]string // other attributes with several values, like schema's mayContain
 	ObjectClass        []string
 	UserAccountControl int
+	USN
+}
+
+// Update sequence numbers tell when object was created and last changed. AttributeUSNs has
+// the latest change of each attribute (by lower case name) which changed after creation.
+type USN struct {
+	USNCreated    int64            `json:"-"`
+	USNChanged    int64            `json:"-"`
+	AttributeUSNs map[string]int64 `json:"-"`
 }
 
 type User struct {
@@ -32,14 +56,43 @@ type User struct {
 	Attributes          map[string]string `json:"attributes"`
 	Groups              []string          `json:"groups"`
 	UserAccountControl  int
+	ObjectGUID          string `json:"-"`
+	Container           string `json:"-"`
+	USN
 }
 
 type Group struct {
-	Cn string `json:"cn"`
+	Cn         string            `json:"cn"`
+	Attributes map[string]string `json:"attributes"`
+	ObjectGUID string            `json:"-"`
+	Container  string            `json:"-"`
+	USN
 }
 
+// Organizational units can only be created by clients. Container of OU is relative to the
+// domain like with users and groups, but empty container means the domain itself.
+type OrganizationalUnit struct {
+	Ou         string
+	Attributes map[string]string
+	ObjectGUID string
+	Container  string
+	USN
+}
+
+// AppConfig contains the configuration and the directory data. Directory can be modified by
+// client requests, so it must be locked while reading or modifying it.
 type AppConfig struct {
+	sync.RWMutex
 	Configuration Configuration
+	TLSConfig     *tls.Config
 	Users         []User
 	Groups        []Group
+	// Users and groups are in CN=Users container, unless they are in one of the OUs or
+	// directly under the domain
+	OrganizationalUnits []OrganizationalUnit
+
+	// Highest update sequence number given to a change in the directory
+	HighestUSN int64
+	// Deleted objects, so that synchronizing clients can find out about deletions
+	Tombstones []LdapElement
 }